@@ -0,0 +1,469 @@
+package cmd
+
+import (
+	"archive/tar"
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const archiveMagic = "PGDMP"
+
+// archiveVersion is the version of pg_dump archive format (not PostgreSQL version)
+type archiveVersion struct {
+	Major byte
+	Minor byte
+	Rev   byte
+}
+
+func (v archiveVersion) String() string {
+	return fmt.Sprintf("%d.%d-%d", v.Major, v.Minor, v.Rev)
+}
+
+func (v archiveVersion) atLeast(major byte, minor byte) bool {
+	return v.Major > major || (v.Major == major && v.Minor >= minor)
+}
+
+// minToolMajor returns the oldest pg_restore major version (server_version_num / 100) able to read the archive
+func (v archiveVersion) minToolMajor() int {
+	switch {
+	case v.atLeast(1, 16):
+		return 1700
+	case v.atLeast(1, 15):
+		return 1600
+	case v.atLeast(1, 14):
+		return 1200
+	case v.atLeast(1, 13):
+		// 1.13 was introduced in 11 and back-patched in 10.3
+		return 1000
+	default:
+		return 0
+	}
+}
+
+// readArchiveVersion reads archive version from the header of a custom archive or a toc.dat file
+func readArchiveVersion(r io.Reader) (archiveVersion, error) {
+	header := make([]byte, len(archiveMagic)+3)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return archiveVersion{}, fmt.Errorf("could not read archive header : %s", err)
+	}
+	if string(header[:len(archiveMagic)]) != archiveMagic {
+		return archiveVersion{}, fmt.Errorf("not a pg_dump archive")
+	}
+	v := archiveVersion{Major: header[5], Minor: header[6]}
+	if v.atLeast(1, 1) {
+		v.Rev = header[7]
+	}
+	return v, nil
+}
+
+// dumpArchiveVersion finds archive version of a custom, directory or tar dump.
+// ok is false for dumps which are not pg_dump archives (plain sql).
+func dumpArchiveVersion(input *dumpInput) (v archiveVersion, ok bool, err error) {
+	switch input.Kind.Format {
+	case formatDirectory:
+		toc, err := os.Open(filepath.Join(input.Path, "toc.dat"))
+		if err != nil {
+			return v, false, err
+		}
+		defer toc.Close()
+		v, err = readArchiveVersion(toc)
+		return v, err == nil, err
+	case formatCustom, formatTar:
+		err = input.inspect(func(r io.Reader) error {
+			if input.Kind.Format == formatTar {
+				// tar archives made by pg_dump start with toc.dat
+				tr := tar.NewReader(r)
+				if _, err := tr.Next(); err != nil {
+					return err
+				}
+				r = tr
+			}
+			v, err = readArchiveVersion(r)
+			return err
+		})
+		return v, err == nil, err
+	default:
+		return v, false, nil
+	}
+}
+
+// Format byte stored in archive header
+const (
+	archiveFormatCustom    = 1
+	archiveFormatTar       = 3
+	archiveFormatDirectory = 5
+)
+
+// Data offset states of custom archive entries
+const (
+	offsetNotSet = 1
+	offsetSet    = 2
+	offsetNoData = 3
+)
+
+// archiveHeader is what pg_dump writes before the table of contents
+type archiveHeader struct {
+	Version       archiveVersion
+	IntSize       int
+	OffSize       int
+	Format        int
+	Compression   string
+	CreatedAt     time.Time
+	Database      string
+	ServerVersion string
+	DumpVersion   string
+}
+
+// archiveEntry is one item of the table of contents
+type archiveEntry struct {
+	DumpId     int
+	HadDumper  bool
+	Tag        string
+	Desc       string
+	Section    int
+	Defn       string
+	Namespace  string
+	Tablespace string
+	Owner      string
+	Deps       []int
+	// Custom archives only
+	DataState  int
+	DataOffset int64
+	// Directory and tar archives only
+	Filename string
+	// Size of data in archive, -1 when unknown
+	Size int64
+}
+
+// archiveToc is the header and table of contents of a pg_dump archive
+type archiveToc struct {
+	Header  archiveHeader
+	Entries []archiveEntry
+}
+
+// archiveReader decodes pg_dump archive primitives, see pg_backup_archiver.c
+type archiveReader struct {
+	r      *bufio.Reader
+	header archiveHeader
+}
+
+func (a *archiveReader) readByte() (byte, error) {
+	return a.r.ReadByte()
+}
+
+// readInt reads a sign byte followed by IntSize little endian bytes
+func (a *archiveReader) readInt() (int, error) {
+	sign := byte(0)
+	var err error
+	if a.header.Version.atLeast(1, 1) {
+		if sign, err = a.r.ReadByte(); err != nil {
+			return 0, err
+		}
+	}
+	value := 0
+	for i := 0; i < a.header.IntSize; i++ {
+		b, err := a.r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		value |= int(b) << (8 * uint(i))
+	}
+	if sign != 0 {
+		value = -value
+	}
+	return value, nil
+}
+
+// maxArchiveString bounds strings of a table of contents, a corrupt length must not allocate gigabytes
+const maxArchiveString = 64 << 20
+
+// readStr reads a length then bytes, null strings (length -1) are returned as "" with ok false
+func (a *archiveReader) readStr() (s string, ok bool, err error) {
+	l, err := a.readInt()
+	if err != nil || l == -1 {
+		return "", false, err
+	}
+	if l < 0 || l > maxArchiveString {
+		return "", false, fmt.Errorf("invalid archive : string of %d bytes", l)
+	}
+	b := make([]byte, l)
+	if _, err := io.ReadFull(a.r, b); err != nil {
+		return "", false, err
+	}
+	return string(b), true, nil
+}
+
+func (a *archiveReader) readOffset() (state int, offset int64, err error) {
+	flag, err := a.readByte()
+	if err != nil {
+		return 0, 0, err
+	}
+	for i := 0; i < a.header.OffSize; i++ {
+		b, err := a.readByte()
+		if err != nil {
+			return 0, 0, err
+		}
+		offset |= int64(b) << (8 * uint(i))
+	}
+	return int(flag), offset, nil
+}
+
+func (a *archiveReader) readHeader() error {
+	v, err := readArchiveVersion(a.r)
+	if err != nil {
+		return err
+	}
+	h := &a.header
+	h.Version = v
+	if !v.atLeast(1, 10) {
+		return fmt.Errorf("archive version %s is too old to be inspected", v)
+	}
+
+	fields := []*int{&h.IntSize, &h.OffSize, &h.Format}
+	for _, field := range fields {
+		b, err := a.readByte()
+		if err != nil {
+			return err
+		}
+		*field = int(b)
+	}
+	if h.IntSize < 1 || h.IntSize > 8 || h.OffSize < 1 || h.OffSize > 8 {
+		return fmt.Errorf("invalid archive header")
+	}
+
+	if v.atLeast(1, 15) {
+		algorithm, err := a.readByte()
+		if err != nil {
+			return err
+		}
+		h.Compression = map[byte]string{0: "none", 1: "gzip", 2: "lz4", 3: "zstd"}[algorithm]
+	} else {
+		level, err := a.readInt()
+		if err != nil {
+			return err
+		}
+		switch {
+		case level == 0:
+			h.Compression = "none"
+		case level < 0:
+			h.Compression = "gzip"
+		default:
+			h.Compression = fmt.Sprintf("gzip level %d", level)
+		}
+	}
+
+	// struct tm fields : sec, min, hour, mday, mon (from 0), year (since 1900), isdst
+	tm := make([]int, 7)
+	for i := range tm {
+		if tm[i], err = a.readInt(); err != nil {
+			return err
+		}
+	}
+	h.CreatedAt = time.Date(tm[5]+1900, time.Month(tm[4]+1), tm[3], tm[2], tm[1], tm[0], 0, time.Local)
+
+	for _, field := range []*string{&h.Database, &h.ServerVersion, &h.DumpVersion} {
+		if *field, _, err = a.readStr(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *archiveReader) readEntry() (e archiveEntry, err error) {
+	v := a.header.Version
+	e.Size = -1
+	if e.DumpId, err = a.readInt(); err != nil {
+		return e, err
+	}
+	hadDumper, err := a.readInt()
+	if err != nil {
+		return e, err
+	}
+	e.HadDumper = hadDumper != 0
+
+	// Catalog table oid and object oid
+	for i := 0; i < 2; i++ {
+		if _, _, err = a.readStr(); err != nil {
+			return e, err
+		}
+	}
+	if e.Tag, _, err = a.readStr(); err != nil {
+		return e, err
+	}
+	if e.Desc, _, err = a.readStr(); err != nil {
+		return e, err
+	}
+	if v.atLeast(1, 11) {
+		if e.Section, err = a.readInt(); err != nil {
+			return e, err
+		}
+	}
+	if e.Defn, _, err = a.readStr(); err != nil {
+		return e, err
+	}
+	// Drop and copy statements
+	for i := 0; i < 2; i++ {
+		if _, _, err = a.readStr(); err != nil {
+			return e, err
+		}
+	}
+	if e.Namespace, _, err = a.readStr(); err != nil {
+		return e, err
+	}
+	if e.Tablespace, _, err = a.readStr(); err != nil {
+		return e, err
+	}
+	if v.atLeast(1, 14) {
+		// Table access method
+		if _, _, err = a.readStr(); err != nil {
+			return e, err
+		}
+	}
+	if v.atLeast(1, 16) {
+		// Relation kind
+		if _, err = a.readInt(); err != nil {
+			return e, err
+		}
+	}
+	if e.Owner, _, err = a.readStr(); err != nil {
+		return e, err
+	}
+	// With oids
+	if _, _, err = a.readStr(); err != nil {
+		return e, err
+	}
+
+	for {
+		dep, ok, err := a.readStr()
+		if err != nil {
+			return e, err
+		}
+		if !ok {
+			break
+		}
+		id, err := strconv.Atoi(dep)
+		if err != nil {
+			return e, fmt.Errorf("invalid dependency %q of entry %d", dep, e.DumpId)
+		}
+		e.Deps = append(e.Deps, id)
+	}
+
+	switch a.header.Format {
+	case archiveFormatCustom:
+		e.DataState, e.DataOffset, err = a.readOffset()
+	case archiveFormatTar, archiveFormatDirectory:
+		e.Filename, _, err = a.readStr()
+	default:
+		err = fmt.Errorf("unsupported archive format %d", a.header.Format)
+	}
+	return e, err
+}
+
+// readArchiveToc reads the header and table of contents of a custom archive or a toc.dat file
+func readArchiveToc(r io.Reader) (*archiveToc, error) {
+	a := &archiveReader{r: bufio.NewReader(r)}
+	if err := a.readHeader(); err != nil {
+		return nil, err
+	}
+	count, err := a.readInt()
+	if err != nil {
+		return nil, err
+	}
+	if count < 0 {
+		return nil, fmt.Errorf("invalid archive : %d toc entries", count)
+	}
+	toc := &archiveToc{Header: a.header}
+	for i := 0; i < count; i++ {
+		e, err := a.readEntry()
+		if err != nil {
+			return nil, fmt.Errorf("could not read toc entry %d : %s", i+1, err)
+		}
+		toc.Entries = append(toc.Entries, e)
+	}
+	return toc, nil
+}
+
+// setCustomSizes computes data sizes from offsets, archiveSize is 0 when unknown
+func (t *archiveToc) setCustomSizes(archiveSize int64) {
+	var offsets []int64
+	for _, e := range t.Entries {
+		if e.DataState == offsetSet {
+			offsets = append(offsets, e.DataOffset)
+		}
+	}
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+	for i := range t.Entries {
+		e := &t.Entries[i]
+		if e.DataState == offsetNoData {
+			e.Size = 0
+		}
+		if e.DataState != offsetSet {
+			continue
+		}
+		next := sort.Search(len(offsets), func(j int) bool { return offsets[j] > e.DataOffset })
+		if next < len(offsets) {
+			e.Size = offsets[next] - e.DataOffset
+		} else if archiveSize > e.DataOffset {
+			e.Size = archiveSize - e.DataOffset
+		}
+	}
+}
+
+// dumpArchiveToc reads the table of contents of a custom, directory or tar dump without pg_restore
+func dumpArchiveToc(input *dumpInput) (*archiveToc, error) {
+	switch input.Kind.Format {
+	case formatDirectory:
+		f, err := os.Open(filepath.Join(input.Path, "toc.dat"))
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		toc, err := readArchiveToc(f)
+		if err != nil {
+			return nil, err
+		}
+		for i := range toc.Entries {
+			e := &toc.Entries[i]
+			if e.Filename == "" {
+				continue
+			}
+			if info, err := os.Stat(filepath.Join(input.Path, e.Filename)); err == nil {
+				e.Size = info.Size()
+			}
+		}
+		return toc, nil
+	case formatCustom, formatTar:
+		var toc *archiveToc
+		err := input.inspect(func(r io.Reader) error {
+			if input.Kind.Format == formatTar {
+				tr := tar.NewReader(r)
+				if _, err := tr.Next(); err != nil {
+					return err
+				}
+				r = tr
+			}
+			var err error
+			toc, err = readArchiveToc(r)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		if input.Kind.Format == formatCustom {
+			var size int64
+			if input.Kind.plainFile() {
+				size = input.Size
+			}
+			toc.setCustomSizes(size)
+		}
+		return toc, nil
+	default:
+		return nil, fmt.Errorf("%s dumps have no table of contents", input.Kind)
+	}
+}
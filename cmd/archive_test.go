@@ -0,0 +1,163 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// archiveWriter builds custom archives the way pg_dump does, with 4 bytes ints and 8 bytes offsets
+type archiveWriter struct {
+	bytes.Buffer
+}
+
+func (w *archiveWriter) int(v int) {
+	if v < 0 {
+		w.WriteByte(1)
+		v = -v
+	} else {
+		w.WriteByte(0)
+	}
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, uint32(v))
+	w.Write(b)
+}
+
+func (w *archiveWriter) str(s string) {
+	w.int(len(s))
+	w.WriteString(s)
+}
+
+func (w *archiveWriter) null() {
+	w.int(-1)
+}
+
+func (w *archiveWriter) offset(state byte, offset int64) {
+	w.WriteByte(state)
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, uint64(offset))
+	w.Write(b)
+}
+
+func (w *archiveWriter) entry(id int, desc, namespace, tag string, state byte, offset int64, deps ...int) {
+	w.int(id)
+	w.int(1)
+	w.str("0")
+	w.str("16390")
+	w.str(tag)
+	w.str(desc)
+	w.int(1)
+	w.str("")
+	w.str("")
+	w.null()
+	w.str(namespace)
+	w.str("")
+	w.null()
+	w.str("postgres")
+	w.str("false")
+	for _, dep := range deps {
+		w.str(strconv.Itoa(dep))
+	}
+	w.null()
+	w.offset(state, offset)
+}
+
+func sampleCustomArchive(minor byte) []byte {
+	w := &archiveWriter{}
+	w.WriteString(archiveMagic)
+	w.Write([]byte{1, minor, 0, 4, 8, archiveFormatCustom})
+	if minor >= 15 {
+		w.WriteByte(3)
+	} else {
+		w.int(-1)
+	}
+	for _, v := range []int{30, 15, 10, 2, 2, 124, 0} {
+		w.int(v)
+	}
+	w.str("shop")
+	w.str("14.5")
+	w.str("14.5")
+
+	w.int(4)
+	w.entry(1, "SCHEMA", "", "sales", offsetNoData, 0)
+	w.entry(2, "TABLE", "sales", "orders", offsetNoData, 0, 1)
+	w.entry(3, "TABLE DATA", "sales", "orders", offsetSet, 1000, 2)
+	w.entry(4, "SEQUENCE SET", "sales", "orders_id_seq", offsetSet, 1600)
+	return w.Bytes()
+}
+
+func Test_ReadArchiveToc(t *testing.T) {
+	for _, minor := range []byte{14, 15} {
+		toc, err := readArchiveToc(bytes.NewReader(sampleCustomArchive(minor)))
+		if err != nil {
+			t.Fatalf("1.%d : %s", minor, err)
+		}
+		h := toc.Header
+		if h.Database != "shop" || h.ServerVersion != "14.5" || h.Format != archiveFormatCustom {
+			t.Errorf("1.%d : unexpected header %+v", minor, h)
+		}
+		want := map[byte]string{14: "gzip", 15: "zstd"}[minor]
+		if h.Compression != want {
+			t.Errorf("1.%d : got compression %s, want %s", minor, h.Compression, want)
+		}
+		if created := time.Date(2024, time.March, 2, 10, 15, 30, 0, time.Local); !h.CreatedAt.Equal(created) {
+			t.Errorf("1.%d : got creation time %s", minor, h.CreatedAt)
+		}
+		if len(toc.Entries) != 4 {
+			t.Fatalf("1.%d : got %d entries", minor, len(toc.Entries))
+		}
+		data := toc.Entries[2]
+		if data.Desc != "TABLE DATA" || data.Namespace != "sales" || data.Tag != "orders" || data.Owner != "postgres" {
+			t.Errorf("1.%d : unexpected entry %+v", minor, data)
+		}
+		if len(data.Deps) != 1 || data.Deps[0] != 2 {
+			t.Errorf("1.%d : got deps %v", minor, data.Deps)
+		}
+	}
+}
+
+func Test_CustomArchiveSizes(t *testing.T) {
+	toc, err := readArchiveToc(bytes.NewReader(sampleCustomArchive(14)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	toc.setCustomSizes(2000)
+	sizes := []int64{0, 0, 600, 400}
+	for i, e := range toc.Entries {
+		if e.Size != sizes[i] {
+			t.Errorf("entry %d : got size %d, want %d", e.DumpId, e.Size, sizes[i])
+		}
+	}
+
+	toc.setCustomSizes(0)
+	if toc.Entries[3].Size != 400 {
+		t.Error("size already known should be kept")
+	}
+}
+
+func Test_ReadArchiveTocRejectsTruncatedArchive(t *testing.T) {
+	archive := sampleCustomArchive(14)
+	if _, err := readArchiveToc(bytes.NewReader(archive[:len(archive)-20])); err == nil {
+		t.Error("expected an error for a truncated archive")
+	}
+}
+
+func Test_ReadArchiveTocRejectsInvalidLengths(t *testing.T) {
+	for _, length := range []int{-2, maxArchiveString + 1, 1<<31 - 1} {
+		w := &archiveWriter{}
+		w.WriteString(archiveMagic)
+		w.Write([]byte{1, 14, 0, 4, 8, archiveFormatCustom})
+		w.int(-1)
+		for _, v := range []int{30, 15, 10, 2, 2, 124, 0} {
+			w.int(v)
+		}
+		w.int(length)
+		_, err := readArchiveToc(bytes.NewReader(w.Bytes()))
+		if err == nil || !strings.Contains(err.Error(), "invalid archive") {
+			t.Errorf("length %d should be rejected, got %v", length, err)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/AlecAivazis/survey/v2"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/spf13/viper"
+	"github.com/ttacon/chalk"
+)
+
+// mfaTokenProvider asks for the code of an MFA device, mfa_code (MFA_CODE in env) avoids the prompt
+func mfaTokenProvider(serial string) func() (string, error) {
+	return func() (string, error) {
+		if code := viper.GetString("mfa_code"); code != "" {
+			return code, nil
+		}
+		if nonInteractive {
+			return "", fmt.Errorf("MFA code is needed, set mfa_code (MFA_CODE in env) to run without prompts")
+		}
+		message := "MFA code :"
+		if serial != "" {
+			message = fmt.Sprintf("MFA code for %s :", serial)
+		}
+		code := ""
+		// Asked in the middle of a command, stdout may hold a json report
+		err := survey.AskOne(&survey.Input{Message: message}, &code, survey.WithValidator(survey.Required), askOn(os.Stderr))
+		return code, err
+	}
+}
+
+// stsSession is sess talking to sts_endpoint when set, to the S3 endpoint or AWS otherwise
+func stsSession(sess *session.Session, aco *AwsConfig) *session.Session {
+	if aco.StsEndpoint == "" {
+		return sess
+	}
+	return sess.Copy(&aws.Config{Endpoint: aws.String(aco.StsEndpoint)})
+}
+
+// assumeRole returns a session using temporary credentials of role_arn, refreshed before they expire
+func assumeRole(sess *session.Session, aco *AwsConfig) *session.Session {
+	log.Printf("Assuming role %s", aco.RoleArn)
+	creds := stscreds.NewCredentials(stsSession(sess, aco), aco.RoleArn, func(p *stscreds.AssumeRoleProvider) {
+		p.RoleSessionName = aco.RoleSessionName
+		if p.RoleSessionName == "" {
+			p.RoleSessionName = cliName
+		}
+		if aco.ExternalId != "" {
+			p.ExternalID = aws.String(aco.ExternalId)
+		}
+		if aco.MfaSerial != "" {
+			p.SerialNumber = aws.String(aco.MfaSerial)
+			p.TokenProvider = mfaTokenProvider(aco.MfaSerial)
+		}
+	})
+	return sess.Copy(&aws.Config{Credentials: creds})
+}
+
+// printAwsIdentity shows which identity requests are signed with, and where its credentials come from
+func printAwsIdentity(out io.Writer, sess *session.Session, aco AwsConfig) error {
+	value, err := sess.Config.Credentials.Get()
+	if err != nil {
+		return fmt.Errorf("no usable AWS credentials : %s", err)
+	}
+	// STS needs a region to sign, any works for GetCallerIdentity
+	if aws.StringValue(sess.Config.Region) == "" {
+		sess = sess.Copy(&aws.Config{Region: aws.String("us-east-1")})
+	}
+	identity, err := sts.New(stsSession(sess, &aco)).GetCallerIdentity(&sts.GetCallerIdentityInput{})
+	if err != nil {
+		return fmt.Errorf("could not get caller identity : %s", err)
+	}
+
+	fmt.Fprintf(out, "%s %s\n", chalk.Bold.TextStyle("Account:    "), aws.StringValue(identity.Account))
+	fmt.Fprintf(out, "%s %s\n", chalk.Bold.TextStyle("Arn:        "), aws.StringValue(identity.Arn))
+	fmt.Fprintf(out, "%s %s\n", chalk.Bold.TextStyle("User id:    "), aws.StringValue(identity.UserId))
+	fmt.Fprintf(out, "%s %s (access key %s)\n", chalk.Bold.TextStyle("Credentials:"), value.ProviderName, maskAccessKey(value.AccessKeyID))
+	if aco.Profile != "" {
+		fmt.Fprintf(out, "%s %s\n", chalk.Bold.TextStyle("Profile:    "), aco.Profile)
+	}
+	if aco.RoleArn != "" {
+		fmt.Fprintf(out, "%s %s\n", chalk.Bold.TextStyle("Role:       "), aco.RoleArn)
+	}
+	fmt.Fprintf(out, "%s %s\n", chalk.Bold.TextStyle("Region:     "), aws.StringValue(sess.Config.Region))
+	return nil
+}
+
+// maskAccessKey keeps the last characters of an access key id, enough to tell keys apart
+func maskAccessKey(id string) string {
+	if len(id) <= 4 {
+		return "****"
+	}
+	return "****" + id[len(id)-4:]
+}
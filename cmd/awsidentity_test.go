@@ -0,0 +1,154 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+const assumeRoleResponse = `<AssumeRoleResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <AssumeRoleResult>
+    <Credentials>
+      <AccessKeyId>ASIAASSUMEDROLE</AccessKeyId>
+      <SecretAccessKey>assumed-secret</SecretAccessKey>
+      <SessionToken>assumed-token</SessionToken>
+      <Expiration>2099-01-01T00:00:00Z</Expiration>
+    </Credentials>
+    <AssumedRoleUser>
+      <Arn>arn:aws:sts::222222222222:assumed-role/backups-reader/cappa</Arn>
+      <AssumedRoleId>AROAEXAMPLE:cappa</AssumedRoleId>
+    </AssumedRoleUser>
+  </AssumeRoleResult>
+</AssumeRoleResponse>`
+
+const callerIdentityResponse = `<GetCallerIdentityResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <GetCallerIdentityResult>
+    <Arn>arn:aws:sts::222222222222:assumed-role/backups-reader/cappa</Arn>
+    <UserId>AROAEXAMPLE:cappa</UserId>
+    <Account>222222222222</Account>
+  </GetCallerIdentityResult>
+</GetCallerIdentityResponse>`
+
+// fakeAws answers STS calls and lists the bucket of grab_test, remembering who signed each request
+type fakeAws struct {
+	sync.Mutex
+	assumeRole map[string]string
+	signers    map[string]string
+}
+
+func (f *fakeAws) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+	w.Header().Set("Content-Type", "text/xml")
+	// Credential=<access key>/<date>/<region>/<service>/aws4_request
+	signer := strings.SplitN(strings.SplitN(r.Header.Get("Authorization"), "Credential=", 2)[1], "/", 2)[0]
+	if r.Method == http.MethodPost {
+		r.ParseForm()
+		f.signers[r.PostForm.Get("Action")] = signer
+		switch r.PostForm.Get("Action") {
+		case "AssumeRole":
+			f.assumeRole = map[string]string{}
+			for k := range r.PostForm {
+				f.assumeRole[k] = r.PostForm.Get(k)
+			}
+			w.Write([]byte(assumeRoleResponse))
+		case "GetCallerIdentity":
+			w.Write([]byte(callerIdentityResponse))
+		}
+		return
+	}
+	f.signers["ListObjectsV2"] = signer
+	w.Write([]byte(listBucketPages[r.URL.Query().Get("continuation-token")]))
+}
+
+func awsStandIn(t *testing.T) (*fakeAws, AwsConfig, func()) {
+	fake := &fakeAws{signers: map[string]string{}}
+	server := httptest.NewServer(fake)
+	awsconfig := AwsConfig{Region: "eu-west-3", Endpoint: server.URL, ForcePathStyle: true, DisableSSL: true}
+	return fake, awsconfig, server.Close
+}
+
+func Test_AssumeRoleWithExternalIdAndMfa(t *testing.T) {
+	fake, awsconfig, stop := awsStandIn(t)
+	defer stop()
+	awsconfig.AwsAccessKeyId, awsconfig.AwsSecretAccessKey = "AKIABASE", "base-secret"
+	awsconfig.RoleArn = "arn:aws:iam::222222222222:role/backups-reader"
+	awsconfig.ExternalId = "cappa-42"
+	awsconfig.MfaSerial = "arn:aws:iam::111111111111:mfa/dev"
+	viper.Set("mfa_code", "123456")
+	defer viper.Set("mfa_code", nil)
+
+	sess, err := getAwsSession(&awsconfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readBucket("backups", "db/", sess); err != nil {
+		t.Fatal(err)
+	}
+	if err := printAwsIdentity(ioutil.Discard, sess, awsconfig); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{"RoleArn": awsconfig.RoleArn, "ExternalId": "cappa-42", "SerialNumber": awsconfig.MfaSerial, "TokenCode": "123456", "RoleSessionName": "cappa"}
+	for k, v := range expected {
+		if fake.assumeRole[k] != v {
+			t.Errorf("AssumeRole %s : expected %q, got %q", k, v, fake.assumeRole[k])
+		}
+	}
+	if fake.signers["AssumeRole"] != "AKIABASE" {
+		t.Errorf("role should be assumed with base keys, got %q", fake.signers["AssumeRole"])
+	}
+	for _, action := range []string{"ListObjectsV2", "GetCallerIdentity"} {
+		if fake.signers[action] != "ASIAASSUMEDROLE" {
+			t.Errorf("%s should use assumed role credentials, got %q", action, fake.signers[action])
+		}
+	}
+}
+
+func Test_NamedProfileWinsOverKeys(t *testing.T) {
+	fake, awsconfig, stop := awsStandIn(t)
+	defer stop()
+	dir, err := ioutil.TempDir("", "cappa-aws")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	credentialsFile := filepath.Join(dir, "credentials")
+	ioutil.WriteFile(credentialsFile, []byte("[backups]\naws_access_key_id = AKIAPROFILE\naws_secret_access_key = profile-secret\n"), 0600)
+	for k, v := range map[string]string{"AWS_SHARED_CREDENTIALS_FILE": credentialsFile, "AWS_CONFIG_FILE": filepath.Join(dir, "config")} {
+		previous, set := os.LookupEnv(k)
+		os.Setenv(k, v)
+		if set {
+			defer os.Setenv(k, previous)
+		} else {
+			defer os.Unsetenv(k)
+		}
+	}
+
+	awsconfig.AwsAccessKeyId, awsconfig.AwsSecretAccessKey = "AKIAKEYS", "keys-secret"
+	awsconfig.Profile = "backups"
+	sess, err := getAwsSession(&awsconfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readBucket("backups", "db/", sess); err != nil {
+		t.Fatal(err)
+	}
+	if fake.signers["ListObjectsV2"] != "AKIAPROFILE" {
+		t.Errorf("expected profile credentials, got %q", fake.signers["ListObjectsV2"])
+	}
+
+	ioutil.WriteFile(filepath.Join(dir, "config"), []byte("[profile broken]\nrole_arn = arn:aws:iam::222222222222:role/backups-reader\ncredential_source = Nowhere\n"), 0600)
+	awsconfig.Profile = "broken"
+	if _, err := getAwsSession(&awsconfig); err == nil {
+		t.Error("an invalid profile should be an error")
+	}
+}
@@ -30,12 +30,22 @@ var snapbackCmd = &cobra.Command{
 	Use:   "back",
 	Short: "Reinstall a snapshot in development database",
 	Run: func(cmd *cobra.Command, args []string) {
-		restoreFromSnapshot()
+		report, err := newRestoreReport("back")
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = restoreFromSnapshot(report)
+		report.finish(err)
+		report.print()
+		if !report.Success {
+			os.Exit(1)
+		}
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(snapbackCmd)
+	snapbackCmd.Flags().StringVarP(&outputFormat, "output", "o", "text", "Output format of the final report : text or json")
 
 	// Here you will define your flags and configuration settings.
 
@@ -48,7 +58,7 @@ func init() {
 	// snapbackCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
 }
 
-func restoreFromSnapshot() {
+func restoreFromSnapshot(report *restoreReport) error {
 	cliDbConn := createConnection(cliDbUrl)
 	defer cliDbConn.Close(context.Background())
 
@@ -67,9 +77,9 @@ func restoreFromSnapshot() {
 		Message: "Select snapshot to revert to primary database :",
 		Options: options,
 	}
-	err = survey.AskOne(prompt, &snapshotSelected, survey.WithValidator(survey.Required))
+	err = survey.AskOne(prompt, &snapshotSelected, survey.WithValidator(survey.Required), askOn(report.text))
 	if err == terminal.InterruptErr {
-		fmt.Println("User terminated prompt")
+		fmt.Fprintln(report.text, "User terminated prompt")
 		os.Exit(0)
 	} else if err != nil {
 		log.Fatal(err)
@@ -86,11 +96,17 @@ func restoreFromSnapshot() {
 			TerminateDatabaseConnections(defaultDbConn, fromDatabase)
 			TerminateDatabaseConnections(defaultDbConn, toDatabase)
 
-			fmt.Printf("Restoring from snapshot %s, please wait ..\n", snapshotSelected)
+			fmt.Fprintf(report.text, "Restoring from snapshot %s, please wait ..\n", snapshotSelected)
 			DropDatabase(defaultDbConn, toDatabase)
 
-			copy_database(defaultDbConn, fromDatabase, toDatabase)
-			fmt.Printf("Restoring from snapshot %s successfull\n", snapshotSelected)
+			if err := copy_database(defaultDbConn, fromDatabase, toDatabase); err != nil {
+				return err
+			}
+			fmt.Fprintf(report.text, "Restoring from snapshot %s successfull\n", snapshotSelected)
+
+			report.Source = snapshotSelected
+			return report.postRestore(trackedDbUrl, toDatabase)
 		}
 	}
+	return fmt.Errorf("Unknown snapshot %s", snapshotSelected)
 }
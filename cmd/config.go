@@ -0,0 +1,342 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/jackc/pgx/v4"
+	"github.com/mitchellh/go-homedir"
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"github.com/ttacon/chalk"
+)
+
+// setting describes a configuration key cappa knows about
+type setting struct {
+	key    string
+	secret bool
+}
+
+// settings lists every key that can be set from environment, flags or config file
+var settings = []setting{
+	{key: "database_url"},
+	{key: "admin_database_url"},
+	{key: "aws_access_key_id", secret: true},
+	{key: "aws_secret_access_key", secret: true},
+	{key: "aws_session_token", secret: true},
+	{key: "aws_profile"},
+	{key: "role_arn"},
+	{key: "external_id"},
+	{key: "mfa_serial"},
+	{key: "mfa_code", secret: true},
+	{key: "role_session_name"},
+	{key: "sts_endpoint"},
+	{key: "source"},
+	{key: "dest"},
+	{key: "bucket"},
+	{key: "region"},
+	{key: "prefix"},
+	{key: "endpoint"},
+	{key: "force_path_style"},
+	{key: "disable_ssl"},
+	{key: "pg_bin_dirs"},
+	{key: "restore.jobs"},
+	{key: "restore.tables"},
+	{key: "restore.exclude_tables"},
+	{key: "restore.schemas"},
+	{key: "restore.schema_only"},
+	{key: "restore.data_only"},
+	{key: "restore.ignore_errors"},
+	{key: "restore.create_missing"},
+	{key: "restore.template"},
+	{key: "restore.encoding"},
+	{key: "restore.lc_collate"},
+	{key: "restore.lc_ctype"},
+	{key: "restore.locale_provider"},
+	{key: "restore.icu_locale"},
+	{key: "post_restore.analyze"},
+	{key: "post_restore.resync_sequences"},
+	{key: "encryption.identity"},
+	{key: "encryption.passphrase", secret: true},
+	{key: "pull.source"},
+	{key: "pull.match"},
+	{key: "pull.regex"},
+	{key: "pull.since"},
+	{key: "pull.sanitize"},
+	{key: "pull.snapshot"},
+	{key: "pull.replace_snapshot"},
+	{key: "pull.steps"},
+}
+
+// configCmd represents the config command
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Inspect and check cappa configuration",
+}
+
+var configShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Print effective configuration and where each value comes from",
+	Run: func(cmd *cobra.Command, args []string) {
+		out := cmd.OutOrStdout()
+
+		used := viper.ConfigFileUsed()
+		if used == "" {
+			fmt.Fprintln(out, "Config file : none")
+		} else if cfgFile != "" {
+			fmt.Fprintf(out, "Config file : %s (from --config flag)\n", used)
+		} else {
+			fmt.Fprintf(out, "Config file : %s\n", used)
+		}
+		for _, candidate := range configCandidates() {
+			if !sameFile(candidate, used) {
+				fmt.Fprintf(out, "Ignored     : %s (shadowed by %s)\n", candidate, used)
+			}
+		}
+		fmt.Fprintln(out)
+
+		table := tablewriter.NewWriter(out)
+		table.SetHeader([]string{"Setting", "Value", "Source"})
+		table.SetBorder(false)
+		for _, s := range settings {
+			table.Append([]string{s.key, displayValue(s, settingValue(s.key)), settingSource(s.key)})
+		}
+		table.Render()
+	},
+}
+
+var configCheckCmd = &cobra.Command{
+	Use:   "check",
+	Short: "Validate configuration and test connectivity",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		out := cmd.OutOrStdout()
+		problems := 0
+		report := func(label string, err error) {
+			if err != nil {
+				problems++
+				fmt.Fprintf(out, "%s %s : %s\n", chalk.Red.Color("✗"), label, err)
+				return
+			}
+			fmt.Fprintf(out, "%s %s\n", chalk.Green.Color("✓"), label)
+		}
+
+		databaseUrl := viper.GetString("database_url")
+		conf, err := configFromUrl(databaseUrl)
+		if err == nil {
+			_, err = isConfigValid(conf)
+		}
+		report("database_url is valid", err)
+
+		if err == nil {
+			trackedDbUrl = databaseUrl
+			defaultDbUrl = siblingDbUrl(databaseUrl, "postgres")
+			cliDbUrl = siblingDbUrl(databaseUrl, cliName)
+
+			report(fmt.Sprintf("server reachable (%s)", conf.Host), checkConnection(defaultDbUrl))
+			report(fmt.Sprintf("tracked database %s reachable", conf.Database), checkConnection(trackedDbUrl))
+			report(fmt.Sprintf("tracker database %s reachable", cliName), checkConnection(cliDbUrl))
+		}
+
+		if bucket := viper.GetString("bucket"); bucket != "" {
+			report(fmt.Sprintf("s3 bucket %s reachable", bucket), checkBucket(bucket))
+		}
+		for _, name := range sourceNames() {
+			report(fmt.Sprintf("source %s reachable", name), checkSource(name))
+		}
+
+		if problems > 0 {
+			return fmt.Errorf("%d problem(s) found in configuration", problems)
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(configCmd)
+	configCmd.AddCommand(configShowCmd)
+	configCmd.AddCommand(configCheckCmd)
+}
+
+// configSearchPaths returns directories searched for a config file, in order of precedence
+func configSearchPaths() []string {
+	var paths []string
+	home, err := homedir.Dir()
+	if err != nil {
+		log.Printf("Unable to locate home directory : %s", err)
+	} else {
+		paths = append(paths, home)
+	}
+	return append(paths, ".")
+}
+
+// configCandidates returns every existing config file cappa could have loaded
+func configCandidates() []string {
+	if cfgFile != "" {
+		return []string{cfgFile}
+	}
+	var found []string
+	for _, dir := range configSearchPaths() {
+		for _, ext := range viper.SupportedExts {
+			candidate := filepath.Join(dir, fmt.Sprintf(".%s.%s", cliName, ext))
+			if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+				abs, err := filepath.Abs(candidate)
+				if err == nil {
+					candidate = abs
+				}
+				found = append(found, candidate)
+			}
+		}
+	}
+	return found
+}
+
+func sameFile(a string, b string) bool {
+	if a == "" || b == "" {
+		return false
+	}
+	ia, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	ib, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(ia, ib)
+}
+
+// settingValue returns the effective value of key, lists are joined with commas
+func settingValue(key string) string {
+	switch viper.Get(key).(type) {
+	case []interface{}, []string:
+		return strings.Join(viper.GetStringSlice(key), ", ")
+	default:
+		return viper.GetString(key)
+	}
+}
+
+// boundFlags remembers the flag bound to each key, viper does not tell
+var boundFlags = map[string]*pflag.Flag{}
+
+// bindFlag binds a flag to a viper key, the flag wins over environment and config file when set
+func bindFlag(key string, flag *pflag.Flag) {
+	boundFlags[key] = flag
+	viper.BindPFlag(key, flag)
+}
+
+// settingSource tells where viper found the effective value of key
+func settingSource(key string) string {
+	if flag := boundFlags[key]; flag != nil && flag.Changed {
+		return fmt.Sprintf("flag --%s", flag.Name)
+	}
+	env := strings.ToUpper(strings.Replace(key, ".", "_", -1))
+	if v, ok := os.LookupEnv(env); ok && v != "" {
+		return fmt.Sprintf("env %s", env)
+	}
+	if viper.InConfig(key) {
+		return viper.ConfigFileUsed()
+	}
+	if settingValue(key) != "" {
+		return "default"
+	}
+	return "unset"
+}
+
+func displayValue(s setting, value string) string {
+	if value == "" {
+		return ""
+	}
+	if s.secret {
+		return maskSecret(value)
+	}
+	return maskUrlPassword(value)
+}
+
+// configFromUrl splits a database url into a Config so it can be validated
+func configFromUrl(databaseUrl string) (*Config, error) {
+	if databaseUrl == "" {
+		return nil, fmt.Errorf("'database_url' not set")
+	}
+	u, err := url.Parse(databaseUrl)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse 'database_url' : %s", err)
+	}
+	port := u.Port()
+	if port == "" {
+		port = "5432"
+	}
+	database := strings.TrimPrefix(u.Path, "/")
+	password, _ := u.User.Password()
+	return &Config{
+		Username: u.User.Username(),
+		Password: password,
+		Host:     u.Hostname(),
+		Port:     port,
+		Database: database,
+		Project:  database,
+	}, nil
+}
+
+// siblingDbUrl returns the connection url to another database on the same server, keeping connection options
+func siblingDbUrl(connUrl string, database string) string {
+	t, _ := url.Parse(connUrl)
+	d := &url.URL{
+		Scheme:   t.Scheme,
+		User:     t.User,
+		Host:     t.Host,
+		Path:     database,
+		RawQuery: t.RawQuery,
+	}
+	return d.String()
+}
+
+func checkConnection(connUrl string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := pgx.Connect(ctx, connUrl)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+	return conn.Ping(ctx)
+}
+
+func checkBucket(bucket string) error {
+	awsconfig := awsConfigFromViper()
+	awsconfig.Bucket = bucket
+	if awsconfig.Region == "" {
+		return fmt.Errorf("'region' not set")
+	}
+	sess, err := getAwsSession(&awsconfig)
+	if err != nil {
+		return err
+	}
+	svc := s3.New(sess)
+	_, err = svc.HeadBucket(&s3.HeadBucketInput{Bucket: aws.String(bucket)})
+	return err
+}
+
+// checkSource connects to a named source and lists it
+func checkSource(name string) error {
+	source, err := loadSource(name)
+	if err != nil {
+		return err
+	}
+	store, prefix, err := openStorage(source)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	_, err = store.List(prefix)
+	return err
+}
@@ -0,0 +1,200 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/spf13/viper"
+)
+
+// databaseLocale holds the settings a database is created with, empty fields keep server defaults
+type databaseLocale struct {
+	Template string
+	Encoding string
+	Collate  string
+	Ctype    string
+	// libc, icu or builtin (17+)
+	Provider string
+	// ICU_LOCALE or BUILTIN_LOCALE, depending on provider
+	ProviderLocale string
+}
+
+// Options of the CREATE DATABASE statement pg_dump stores in the DATABASE entry, e.g.
+// `CREATE DATABASE shop WITH TEMPLATE = template0 ENCODING = 'UTF8' LOCALE_PROVIDER = libc LOCALE = 'en_US.UTF-8';`
+var createDatabaseOptionPattern = regexp.MustCompile(`\b(ENCODING|LC_COLLATE|LC_CTYPE|LOCALE|LOCALE_PROVIDER|ICU_LOCALE|BUILTIN_LOCALE) = ('(?:[^']|'')*'|\w+)`)
+
+// parseCreateDatabase reads locale settings from a CREATE DATABASE statement
+func parseCreateDatabase(defn string) databaseLocale {
+	var l databaseLocale
+	for _, m := range createDatabaseOptionPattern.FindAllStringSubmatch(defn, -1) {
+		value := m[2]
+		if strings.HasPrefix(value, "'") {
+			value = strings.Replace(value[1:len(value)-1], "''", "'", -1)
+		}
+		switch m[1] {
+		case "ENCODING":
+			l.Encoding = value
+		case "LC_COLLATE":
+			l.Collate = value
+		case "LC_CTYPE":
+			l.Ctype = value
+		case "LOCALE":
+			l.Collate, l.Ctype = value, value
+		case "LOCALE_PROVIDER":
+			l.Provider = value
+		case "ICU_LOCALE", "BUILTIN_LOCALE":
+			l.ProviderLocale = value
+		}
+	}
+	return l
+}
+
+// archiveLocale finds locale settings in the DATABASE entry of an archive (pg_dump 11+)
+func archiveLocale(toc *archiveToc) (databaseLocale, bool) {
+	for _, e := range toc.Entries {
+		if e.Desc == "DATABASE" && e.Defn != "" {
+			return parseCreateDatabase(e.Defn), true
+		}
+	}
+	return databaseLocale{}, false
+}
+
+// readDatabaseLocale reads locale settings of an existing database
+func readDatabaseLocale(conn *pgx.Conn, database string, server int) (databaseLocale, error) {
+	l := databaseLocale{Provider: "libc"}
+	query := "SELECT pg_encoding_to_char(encoding), datcollate, datctype, 'c', '' FROM pg_database WHERE datname = $1"
+	switch {
+	case server >= 1700:
+		query = "SELECT pg_encoding_to_char(encoding), datcollate, datctype, datlocprovider, coalesce(datlocale, '') FROM pg_database WHERE datname = $1"
+	case server >= 1500:
+		query = "SELECT pg_encoding_to_char(encoding), datcollate, datctype, datlocprovider, coalesce(daticulocale, '') FROM pg_database WHERE datname = $1"
+	}
+	var provider string
+	err := conn.QueryRow(context.Background(), query, database).Scan(&l.Encoding, &l.Collate, &l.Ctype, &provider, &l.ProviderLocale)
+	if err != nil {
+		return l, err
+	}
+	l.Provider = map[string]string{"c": "libc", "i": "icu", "b": "builtin"}[provider]
+	return l, nil
+}
+
+// withOverrides applies restore.* settings from flags, env or config file
+func (l databaseLocale) withOverrides() databaseLocale {
+	overrides := []struct {
+		key   string
+		field *string
+	}{
+		{"restore.template", &l.Template},
+		{"restore.encoding", &l.Encoding},
+		{"restore.lc_collate", &l.Collate},
+		{"restore.lc_ctype", &l.Ctype},
+		{"restore.locale_provider", &l.Provider},
+		{"restore.icu_locale", &l.ProviderLocale},
+	}
+	for _, o := range overrides {
+		if v := viper.GetString(o.key); v != "" {
+			*o.field = v
+		}
+	}
+	return l
+}
+
+var localeProviders = map[string]bool{"libc": true, "icu": true, "builtin": true}
+
+// validate checks settings which can not be quoted in CREATE DATABASE
+func (l databaseLocale) validate() error {
+	if l.Provider != "" && !localeProviders[l.Provider] {
+		return fmt.Errorf("locale provider must be libc, icu or builtin, not %q", l.Provider)
+	}
+	return nil
+}
+
+// createOptions returns CREATE DATABASE options this server understands.
+// template0 is needed as soon as encoding or locale differ from template1.
+func (l databaseLocale) createOptions(server int) []string {
+	options := []string{fmt.Sprintf("TEMPLATE = %s", pgx.Identifier{l.Template}.Sanitize())}
+	add := func(name string, value string) {
+		if value != "" {
+			options = append(options, fmt.Sprintf("%s = '%s'", name, strings.Replace(value, "'", "''", -1)))
+		}
+	}
+	add("ENCODING", l.Encoding)
+	add("LC_COLLATE", l.Collate)
+	add("LC_CTYPE", l.Ctype)
+
+	provider := l.Provider
+	if (provider == "icu" && server < 1500) || (provider == "builtin" && server < 1700) {
+		log.Printf("Locale provider %s is not supported by server %s, using libc", provider, formatMajor(server))
+		provider = ""
+	}
+	// Provider is a keyword, it can not be quoted : only known ones are written
+	if server >= 1500 && localeProviders[provider] {
+		options = append(options, "LOCALE_PROVIDER = "+provider)
+		switch provider {
+		case "icu":
+			add("ICU_LOCALE", l.ProviderLocale)
+		case "builtin":
+			add("BUILTIN_LOCALE", l.ProviderLocale)
+		}
+	}
+	return options
+}
+
+func (l databaseLocale) String() string {
+	s := fmt.Sprintf("encoding %s, collate %s, ctype %s", l.Encoding, l.Collate, l.Ctype)
+	if l.Provider != "" && l.Provider != "libc" {
+		s += fmt.Sprintf(", %s locale %s", l.Provider, l.ProviderLocale)
+	}
+	return s
+}
+
+// restoreLocale finds settings of the database to create : from the archive, or from the tracked database
+// for plain dumps, then config overrides
+func restoreLocale(conn *pgx.Conn, toc *archiveToc, server int) databaseLocale {
+	var l databaseLocale
+	found := false
+	if toc != nil {
+		l, found = archiveLocale(toc)
+	}
+	if !found && DatabaseExists(conn, getProjectName()) {
+		var err error
+		l, err = readDatabaseLocale(conn, getProjectName(), server)
+		if err != nil {
+			log.Printf("Could not read locale of %s : %s", getProjectName(), err)
+			l = databaseLocale{}
+		}
+	}
+	if l.Template == "" {
+		l.Template = "template0"
+	}
+	return l.withOverrides()
+}
+
+// Errors of CREATE DATABASE telling the server does not support a locale, an encoding or a provider
+var unsupportedLocalePattern = regexp.MustCompile(`(?i)locale|collation|ctype|encoding|icu|provider`)
+
+// createRestoreDatabase creates database with locale settings. It falls back to server defaults,
+// with a warning in the report, only when the server does not support the locale
+// (e.g. en_US.UTF-8 missing on an alpine image).
+func createRestoreDatabase(conn *pgx.Conn, database string, locale databaseLocale, report *restoreReport) error {
+	server, err := serverMajor(conn)
+	if err != nil {
+		log.Printf("Could not read server version : %s", err)
+	}
+	err = CreateDatabaseWith(conn, database, locale.createOptions(server)...)
+	if err == nil {
+		return nil
+	}
+	if !unsupportedLocalePattern.MatchString(err.Error()) {
+		return fmt.Errorf("could not create database %s : %s", database, err)
+	}
+	report.warn(fmt.Sprintf("Could not create database with %s : %s, using server defaults", locale, redact(err.Error())))
+	if err := CreateDatabaseWith(conn, database); err != nil {
+		return fmt.Errorf("could not create database %s : %s", database, err)
+	}
+	return nil
+}
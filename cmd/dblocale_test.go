@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_ParseCreateDatabase(t *testing.T) {
+	cases := []struct {
+		defn string
+		want databaseLocale
+	}{
+		{
+			"CREATE DATABASE shop WITH TEMPLATE = template0 ENCODING = 'UTF8' LC_COLLATE = 'fr_FR.UTF-8' LC_CTYPE = 'en_US.UTF-8';",
+			databaseLocale{Encoding: "UTF8", Collate: "fr_FR.UTF-8", Ctype: "en_US.UTF-8"},
+		},
+		{
+			"CREATE DATABASE shop WITH TEMPLATE = template0 ENCODING = 'UTF8' LOCALE_PROVIDER = libc LOCALE = 'C';",
+			databaseLocale{Encoding: "UTF8", Collate: "C", Ctype: "C", Provider: "libc"},
+		},
+		{
+			"CREATE DATABASE shop WITH TEMPLATE = template0 ENCODING = 'UTF8' LOCALE_PROVIDER = icu LOCALE = 'en_US.UTF-8' ICU_LOCALE = 'de-DE';",
+			databaseLocale{Encoding: "UTF8", Collate: "en_US.UTF-8", Ctype: "en_US.UTF-8", Provider: "icu", ProviderLocale: "de-DE"},
+		},
+	}
+	for _, c := range cases {
+		if got := parseCreateDatabase(c.defn); got != c.want {
+			t.Errorf("%q : got %+v, want %+v", c.defn, got, c.want)
+		}
+	}
+}
+
+func Test_CreateDatabaseOptions(t *testing.T) {
+	locale := databaseLocale{Template: "template0", Encoding: "UTF8", Collate: "en_US.UTF-8", Ctype: "en_US.UTF-8", Provider: "icu", ProviderLocale: "de-DE"}
+
+	want := []string{`TEMPLATE = "template0"`, "ENCODING = 'UTF8'", "LC_COLLATE = 'en_US.UTF-8'", "LC_CTYPE = 'en_US.UTF-8'", "LOCALE_PROVIDER = icu", "ICU_LOCALE = 'de-DE'"}
+	if got := locale.createOptions(1600); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// ICU is only known since 15
+	if got := locale.createOptions(1400); !reflect.DeepEqual(got, want[:4]) {
+		t.Errorf("got %v, want %v", got, want[:4])
+	}
+
+	if err := (databaseLocale{Provider: "icu; DROP DATABASE prod"}).validate(); err == nil {
+		t.Error("unknown provider should be rejected")
+	}
+	unknown := databaseLocale{Template: "template0", Provider: "libc2"}
+	if got := unknown.createOptions(1600); len(got) != 1 {
+		t.Errorf("unknown provider should not be written, got %v", got)
+	}
+
+	quoted := databaseLocale{Template: "template0", Collate: "it's"}
+	if got := quoted.createOptions(1600); got[1] != "LC_COLLATE = 'it''s'" {
+		t.Errorf("got %v", got)
+	}
+}
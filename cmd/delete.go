@@ -30,7 +30,8 @@ var removeCmd = &cobra.Command{
 	Use:   "delete",
 	Short: "Delete snapshot",
 	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Println("remove called")
+		out := cmd.OutOrStdout()
+		fmt.Fprintln(out, "remove called")
 
 		cliDbConn := createConnection(cliDbUrl)
 		defer cliDbConn.Close(context.Background())
@@ -71,11 +72,11 @@ var removeCmd = &cobra.Command{
 		i, _, err := prompt.Run()
 
 		if err != nil {
-			fmt.Printf("Prompt failed %v\n", err)
+			fmt.Fprintf(out, "Prompt failed %v\n", err)
 			return
 		}
 
-		fmt.Printf("Removing snapshot %s\nPlease wait ...\n", snapshots[i].Name)
+		fmt.Fprintf(out, "Removing snapshot %s\nPlease wait ...\n", snapshots[i].Name)
 
 		snap := snapshots[i]
 
@@ -0,0 +1,262 @@
+package cmd
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"gopkg.in/cheggaaa/pb.v1"
+)
+
+// Single part uploads have the md5 of the object as ETag, multipart ones end with -<parts>
+var md5EtagPattern = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// partPaths returns where an unfinished download is kept, and the file remembering which object version it holds.
+// Both start with cappa- so restore never lists them.
+func partPaths(destination string, filename string) (part string, etag string) {
+	part = filepath.Join(destination, fmt.Sprintf("cappa-%s.part", filename))
+	return part, part + ".etag"
+}
+
+// objectVersion identifies the version of an object a partial file holds, its etag or else its size and
+// modification date. It is empty when neither is known and a partial file cannot be trusted.
+func objectVersion(obj remoteObject) string {
+	if obj.etag != "" {
+		return obj.etag
+	}
+	if obj.updated.IsZero() {
+		return ""
+	}
+	return fileEtag(obj.size, obj.updated)
+}
+
+// resumeOffset returns how many bytes of the object are already downloaded, 0 when the partial file
+// is missing, belongs to another version of the object or when the version is unknown
+func resumeOffset(partPath string, etagPath string, version string, size int64) int64 {
+	info, err := os.Stat(partPath)
+	if err != nil {
+		return 0
+	}
+	saved, err := ioutil.ReadFile(etagPath)
+	if version != "" && err == nil && string(saved) == version && info.Size() <= size {
+		return info.Size()
+	}
+	if version == "" {
+		log.Printf("Discarding partial download %s, object version is unknown", partPath)
+	} else {
+		log.Printf("Discarding partial download %s, object changed", partPath)
+	}
+	removeDownload(partPath, etagPath)
+	return 0
+}
+
+func removeDownload(partPath string, etagPath string) {
+	for _, p := range []string{partPath, etagPath} {
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			log.Printf("Could not remove %s : %s", p, err)
+		}
+	}
+}
+
+// Download downloads an object of a storage, or one of its versions, to destination/filename.
+// An interrupted download is resumed by the next call. The file is checked against object size, md5
+// and a .sha256 sidecar object, then its table of contents is read, before being moved to its final name.
+// Messages and progress are printed to out.
+func Download(store storage, obj remoteObject, filename string, destination string, out io.Writer) error {
+	obj, err := store.Stat(obj)
+	if err != nil {
+		return err
+	}
+
+	partPath, etagPath := partPaths(destination, filename)
+	version := objectVersion(obj)
+	offset := resumeOffset(partPath, etagPath, version, obj.size)
+	if offset == 0 {
+		if err := ioutil.WriteFile(etagPath, []byte(version), 0600); err != nil {
+			return err
+		}
+	} else {
+		fmt.Fprintf(out, "Resuming download of %s at %s\n", filename, formatSize(offset))
+	}
+
+	if offset < obj.size {
+		if err := downloadRange(store, obj, partPath, offset, out); err != nil {
+			if err == errObjectChanged {
+				removeDownload(partPath, etagPath)
+				return fmt.Errorf("%s changed during download, run grab again", obj.key)
+			}
+			return fmt.Errorf("download interrupted, run grab again to resume : %s", err)
+		}
+	}
+
+	if err := verifyDownload(store, obj, partPath, out); err != nil {
+		removeDownload(partPath, etagPath)
+		return err
+	}
+	if err := validateDump(partPath); err != nil {
+		removeDownload(partPath, etagPath)
+		return err
+	}
+
+	if err := os.Rename(partPath, filepath.Join(destination, filename)); err != nil {
+		return err
+	}
+	if err := os.Remove(etagPath); err != nil {
+		log.Printf("Could not remove %s : %s", etagPath, err)
+	}
+	entry := manifestEntry{Storage: store.String(), Key: obj.key, VersionId: obj.versionId, ETag: obj.etag, Size: obj.size, DownloadedAt: time.Now()}
+	if err := recordDownload(destination, filename, entry); err != nil {
+		log.Printf("Could not record download in manifest : %s", err)
+	}
+	fmt.Fprintf(out, "Downloaded %s to %s\n", filename, destination)
+	return nil
+}
+
+// downloadRange appends object bytes from offset to the partial file, only if object did not change
+func downloadRange(store storage, obj remoteObject, partPath string, offset int64, out io.Writer) error {
+	f, err := os.OpenFile(partPath, os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	body, err := store.Open(obj, offset)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	bar := pb.New64(obj.size).SetUnits(pb.U_BYTES)
+	bar.Output = out
+	bar.Set64(offset)
+	bar.Start()
+	defer bar.Finish()
+	if _, err := io.Copy(f, bar.NewProxyReader(body)); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// verifyDownload compares the file with object size, md5 and .sha256 sidecar when they are known
+func verifyDownload(store storage, obj remoteObject, path string, out io.Writer) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.Size() != obj.size {
+		return fmt.Errorf("downloaded %d bytes but %s has %d", info.Size(), obj.key, obj.size)
+	}
+
+	md5sum, sha256sum, err := fileChecksums(path)
+	if err != nil {
+		return err
+	}
+	if obj.md5 != "" {
+		if md5sum != obj.md5 {
+			return fmt.Errorf("md5 of downloaded file %s does not match object md5 %s", md5sum, obj.md5)
+		}
+		log.Printf("md5 %s matches object", md5sum)
+	} else {
+		log.Printf("md5 of %s is not known (multipart upload or storage without checksums), not checked", obj.key)
+	}
+
+	// Sidecar holds the checksum of the current version only
+	if obj.versionId != "" {
+		log.Printf("Version %s is not checked against %s.sha256", obj.versionId, obj.key)
+		return nil
+	}
+	expected, err := sidecarSha256(store, obj.key)
+	if err != nil {
+		return err
+	}
+	if expected != "" {
+		if expected != sha256sum {
+			return fmt.Errorf("sha256 of downloaded file %s does not match %s.sha256 (%s)", sha256sum, obj.key, expected)
+		}
+		fmt.Fprintln(out, "Checksum matches", obj.key+".sha256")
+	}
+	return nil
+}
+
+// fileChecksums reads a file once and returns its md5 and sha256
+func fileChecksums(path string) (string, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", "", err
+	}
+	defer f.Close()
+	md5h, sha256h := md5.New(), sha256.New()
+	if _, err := io.Copy(io.MultiWriter(md5h, sha256h), f); err != nil {
+		return "", "", err
+	}
+	return hex.EncodeToString(md5h.Sum(nil)), hex.EncodeToString(sha256h.Sum(nil)), nil
+}
+
+// sidecarSha256 reads the checksum from a <key>.sha256 object (sha256sum output), empty when there is none
+func sidecarSha256(store storage, filekey string) (string, error) {
+	body, err := store.Open(remoteObject{key: filekey + ".sha256"}, 0)
+	// Without s3:ListBucket permission, missing keys answer 403
+	if err == errObjectNotFound || errors.Is(err, errAccessDenied) {
+		log.Printf("No %s.sha256 checksum found", filekey)
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+	scanner := bufio.NewScanner(io.LimitReader(body, 4096))
+	scanner.Split(bufio.ScanWords)
+	if !scanner.Scan() {
+		return "", fmt.Errorf("%s.sha256 is empty", filekey)
+	}
+	sum := strings.ToLower(scanner.Text())
+	if len(sum) != sha256.Size*2 {
+		return "", fmt.Errorf("%s.sha256 does not hold a sha256 checksum", filekey)
+	}
+	return sum, nil
+}
+
+// optionalString is nil for empty values, so they are not sent
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return aws.String(s)
+}
+
+// validateDump makes sure a downloaded file is a dump cappa can restore, reading the table of contents of archives
+func validateDump(path string) error {
+	input, err := openLocalSource(path)
+	if err == errNoDecryptionKey {
+		log.Printf("Downloaded dump is encrypted and no key is configured, it was not validated")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer input.Close()
+	if !input.Kind.restorable() {
+		return fmt.Errorf("downloaded file is not a dump cappa can restore")
+	}
+	if input.Kind.usesPgRestore() {
+		if _, err := dumpArchiveToc(input); err != nil {
+			return fmt.Errorf("downloaded archive is invalid : %s", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,232 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeBucket serves objects of a bucket named backups with ranges, ETags and If-Match like S3.
+// Previous versions are stored as <key>?versionId=<id>.
+type fakeBucket struct {
+	objects map[string][]byte
+	ranges  []string
+	// Keys answering 403, like with a wrong role or KMS key
+	denied map[string]bool
+}
+
+func (b *fakeBucket) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/backups/")
+	if v := r.URL.Query().Get("versionId"); v != "" {
+		key += "?versionId=" + v
+	}
+	if b.denied[key] {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>AccessDenied</Code><Message>Access Denied</Message></Error>`)
+		return
+	}
+	content, ok := b.objects[key]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>NoSuchKey</Code><Message>not found</Message></Error>`)
+		return
+	}
+	if r.Method == http.MethodGet {
+		b.ranges = append(b.ranges, r.Header.Get("Range"))
+	}
+	sum := md5.Sum(content)
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:])+`"`)
+	http.ServeContent(w, r, key, time.Now(), bytes.NewReader(content))
+}
+
+func downloadFixture(t *testing.T, objects map[string][]byte) (bucket *fakeBucket, dir string, download func(remoteObject) error, cleanup func()) {
+	bucket = &fakeBucket{objects: objects}
+	server := httptest.NewServer(bucket)
+	dir, err := ioutil.TempDir("", "cappa-download")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cleanup = func() {
+		server.Close()
+		os.RemoveAll(dir)
+	}
+	awsconfig := AwsConfig{AwsAccessKeyId: "key", AwsSecretAccessKey: "secret", Region: "us-east-1", Endpoint: server.URL, ForcePathStyle: true, DisableSSL: true}
+	sess, err := getAwsSession(&awsconfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &s3Storage{bucket: "backups", sess: sess}
+	download = func(key remoteObject) error {
+		return Download(store, key, key.localName(), dir, ioutil.Discard)
+	}
+	return bucket, dir, download, cleanup
+}
+
+func assertNoPartialFiles(t *testing.T, dir string) {
+	matches, _ := filepath.Glob(filepath.Join(dir, "cappa-*"))
+	if len(matches) > 0 {
+		t.Errorf("partial files left behind : %v", matches)
+	}
+}
+
+func Test_DownloadChecksSidecarAndArchive(t *testing.T) {
+	dump := sampleCustomArchive(14)
+	sum := sha256.Sum256(dump)
+	_, dir, download, cleanup := downloadFixture(t, map[string][]byte{
+		"db/app.dump":        dump,
+		"db/app.dump.sha256": []byte(hex.EncodeToString(sum[:]) + "  app.dump\n"),
+	})
+	defer cleanup()
+
+	if err := download(remoteObject{key: "db/app.dump"}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadFile(filepath.Join(dir, "app.dump"))
+	if err != nil || !bytes.Equal(got, dump) {
+		t.Errorf("downloaded file differs from object (%v)", err)
+	}
+	assertNoPartialFiles(t, dir)
+
+	manifest, err := loadManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := manifest.Dumps["app.dump"]
+	if entry == nil || entry.origin() != "s3://backups/db/app.dump" || entry.Size != int64(len(dump)) {
+		t.Errorf("download not recorded in manifest : %+v", entry)
+	}
+}
+
+func Test_DownloadResumesPartialFile(t *testing.T) {
+	dump := sampleCustomArchive(15)
+	bucket, dir, download, cleanup := downloadFixture(t, map[string][]byte{"db/app.dump": dump})
+	defer cleanup()
+
+	sum := md5.Sum(dump)
+	partPath, etagPath := partPaths(dir, "app.dump")
+	ioutil.WriteFile(partPath, dump[:100], 0600)
+	ioutil.WriteFile(etagPath, []byte(`"`+hex.EncodeToString(sum[:])+`"`), 0600)
+
+	if err := download(remoteObject{key: "db/app.dump"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(bucket.ranges) != 1 || bucket.ranges[0] != "bytes=100-" {
+		t.Errorf("expected a single ranged request from byte 100, got %q", bucket.ranges)
+	}
+	got, _ := ioutil.ReadFile(filepath.Join(dir, "app.dump"))
+	if !bytes.Equal(got, dump) {
+		t.Error("resumed file differs from object")
+	}
+	assertNoPartialFiles(t, dir)
+}
+
+func Test_PartialFilesOfUnknownVersionsAreNotResumed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cappa-resume")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	partPath, etagPath := partPaths(dir, "app.dump")
+	modified := time.Date(2020, 3, 1, 4, 0, 0, 0, time.UTC)
+	version := objectVersion(remoteObject{size: 500, updated: modified})
+	if version == "" || objectVersion(remoteObject{size: 500}) != "" {
+		t.Fatalf("unexpected versions %q", version)
+	}
+
+	for _, c := range []struct {
+		saved    string
+		version  string
+		expected int64
+	}{
+		{version, version, 100},
+		{version, objectVersion(remoteObject{size: 500, updated: modified.Add(time.Second)}), 0},
+		{"", "", 0},
+	} {
+		ioutil.WriteFile(partPath, make([]byte, 100), 0600)
+		ioutil.WriteFile(etagPath, []byte(c.saved), 0600)
+		if offset := resumeOffset(partPath, etagPath, c.version, 500); offset != c.expected {
+			t.Errorf("version %q of %q : expected offset %d, got %d", c.version, c.saved, c.expected, offset)
+		}
+		if _, err := os.Stat(partPath); (err == nil) != (c.expected > 0) {
+			t.Errorf("version %q of %q : partial file kept is %v", c.version, c.saved, err == nil)
+		}
+	}
+}
+
+func Test_DownloadRejectsCorruptedFiles(t *testing.T) {
+	dump := sampleCustomArchive(14)
+	_, dir, download, cleanup := downloadFixture(t, map[string][]byte{
+		"db/app.dump":        dump,
+		"db/app.dump.sha256": []byte(strings.Repeat("0", 64)),
+		"db/notes.txt":       []byte("\x00\x01 not a dump"),
+	})
+	defer cleanup()
+
+	for _, key := range []string{"db/app.dump", "db/notes.txt"} {
+		if err := download(remoteObject{key: key}); err == nil {
+			t.Errorf("%s should be rejected", key)
+		}
+		if _, err := os.Stat(filepath.Join(dir, filepath.Base(key))); !os.IsNotExist(err) {
+			t.Errorf("%s should not be kept", key)
+		}
+	}
+	assertNoPartialFiles(t, dir)
+}
+
+func Test_DownloadPreviousVersion(t *testing.T) {
+	current, previous := sampleCustomArchive(14), sampleCustomArchive(15)
+	_, dir, download, cleanup := downloadFixture(t, map[string][]byte{
+		"db/latest.dump":              current,
+		"db/latest.dump?versionId=v1": previous,
+		"db/latest.dump.sha256":       []byte(strings.Repeat("0", 64)),
+	})
+	defer cleanup()
+
+	version := remoteObject{key: "db/latest.dump", updated: time.Date(2026, 10, 12, 8, 0, 0, 0, time.UTC), versionId: "v1"}
+	if version.localName() != "latest.2026-10-12T080000.dump" {
+		t.Fatalf("unexpected local name %s", version.localName())
+	}
+	// Sidecar is the checksum of the current version, not checked
+	if err := download(version); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadFile(filepath.Join(dir, version.localName()))
+	if err != nil || !bytes.Equal(got, previous) {
+		t.Errorf("expected previous version (%v)", err)
+	}
+	manifest, err := loadManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry := manifest.Dumps[version.localName()]; entry == nil || entry.VersionId != "v1" {
+		t.Errorf("version not recorded in manifest : %+v", entry)
+	}
+}
+
+func Test_DownloadReportsAccessDenied(t *testing.T) {
+	bucket, _, download, cleanup := downloadFixture(t, map[string][]byte{"db/app.dump": sampleCustomArchive(14)})
+	defer cleanup()
+
+	// A sidecar which can not be read is like a missing one
+	bucket.denied = map[string]bool{"db/app.dump.sha256": true}
+	if err := download(remoteObject{key: "db/app.dump"}); err != nil {
+		t.Fatal(err)
+	}
+
+	bucket.denied["db/other.dump"] = true
+	err := download(remoteObject{key: "db/other.dump"})
+	if !errors.Is(err, errAccessDenied) || !strings.Contains(err.Error(), "403") {
+		t.Errorf("refused dump should report access denied, got %v", err)
+	}
+}
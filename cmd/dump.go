@@ -0,0 +1,130 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+	"github.com/ttacon/chalk"
+	"github.com/xeonx/timeago"
+	"gopkg.in/cheggaaa/pb.v1"
+)
+
+var infoDirectory string
+
+// dumpCmd groups commands working on dump files, they never need a database
+var dumpCmd = &cobra.Command{
+	Use:   "dump",
+	Short: "Inspect dump files",
+}
+
+var dumpInfoCmd = &cobra.Command{
+	Use:   "info [source]",
+	Short: "Show what a dump contains without restoring it",
+	Long: `Read the header and table of contents of a custom, directory or tar dump, no PostgreSQL client is needed.
+Source can be a local path, - for stdin, an http(s):// url, s3://bucket/key, gs://bucket/key or sftp://host/path, a dump is picked in --dir otherwise.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var input *dumpInput
+		var err error
+		if len(args) == 1 {
+			input, err = openSource(args[0])
+		} else {
+			var name string
+			name, err = PickFileIn(infoDirectory, textOutput())
+			if err != nil {
+				return err
+			}
+			input, err = openLocalSource(filepath.Join(infoDirectory, name))
+		}
+		if err != nil {
+			return err
+		}
+		defer input.Close()
+
+		toc, err := dumpArchiveToc(input)
+		if err != nil {
+			return err
+		}
+		printDumpInfo(cmd.OutOrStdout(), input, toc)
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(dumpCmd)
+	dumpCmd.AddCommand(dumpInfoCmd)
+	dumpInfoCmd.Flags().StringVar(&infoDirectory, "dir", ".cappa", "Directory to look dumps files for")
+}
+
+func printDumpInfo(out io.Writer, input *dumpInput, toc *archiveToc) {
+	h := toc.Header
+	description := input.Kind.String()
+	if input.Size > 0 {
+		description += ", " + formatSize(input.Size)
+	}
+	fmt.Fprintf(out, "%s %s (%s)\n", chalk.Bold.TextStyle("Dump:       "), input.Name, description)
+	fmt.Fprintf(out, "%s %s\n", chalk.Bold.TextStyle("Archive:    "), h.Version)
+	fmt.Fprintf(out, "%s %s\n", chalk.Bold.TextStyle("Database:   "), h.Database)
+	fmt.Fprintf(out, "%s %s (%s)\n", chalk.Bold.TextStyle("Created:    "), h.CreatedAt.Format("2006-01-02 15:04:05"), timeago.English.Format(h.CreatedAt))
+	fmt.Fprintf(out, "%s %s\n", chalk.Bold.TextStyle("Compression:"), h.Compression)
+	fmt.Fprintf(out, "%s %s\n", chalk.Bold.TextStyle("Server:     "), h.ServerVersion)
+	fmt.Fprintf(out, "%s %s\n\n", chalk.Bold.TextStyle("pg_dump:    "), h.DumpVersion)
+
+	table := tablewriter.NewWriter(out)
+	table.SetHeader([]string{"Type", "Schema", "Name", "Size"})
+	table.SetBorder(false)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+
+	var schemas, tables, data int
+	var dataSize int64
+	for _, e := range toc.Entries {
+		size := ""
+		switch {
+		case e.Desc == "SCHEMA":
+			schemas++
+		case e.Desc == "TABLE":
+			tables++
+		case dataDescs[e.Desc]:
+			data++
+			size = formatSize(e.Size)
+			if e.Size > 0 {
+				dataSize += e.Size
+			}
+		default:
+			continue
+		}
+		table.Append([]string{e.Desc, e.Namespace, e.Tag, size})
+	}
+	table.Render()
+
+	fmt.Fprintf(out, "\n%d schemas, %d tables, %d data items, %s of data in archive\n", schemas, tables, data, formatSize(dataSize))
+}
+
+// dumpSummary describes a dump in one line, falls back to its kind when table of contents can not be read
+func dumpSummary(input *dumpInput) string {
+	if !input.Kind.usesPgRestore() {
+		return input.Kind.String()
+	}
+	toc, err := dumpArchiveToc(input)
+	if err != nil {
+		return input.Kind.String()
+	}
+	tables := 0
+	for _, e := range toc.Entries {
+		if e.Desc == "TABLE" {
+			tables++
+		}
+	}
+	return fmt.Sprintf("%s, %s, %s, %d tables", input.Kind, toc.Header.Database, timeago.English.Format(toc.Header.CreatedAt), tables)
+}
+
+// formatSize prints bytes for humans, negative sizes are unknown
+func formatSize(size int64) string {
+	if size < 0 {
+		return "?"
+	}
+	return pb.Format(size).To(pb.U_BYTES).String()
+}
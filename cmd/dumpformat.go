@@ -0,0 +1,293 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+	"github.com/ulikunitz/xz"
+)
+
+type dumpFormat int
+
+const (
+	formatUnknown dumpFormat = iota
+	formatCustom
+	formatTar
+	formatDirectory
+	formatPlain
+)
+
+func (f dumpFormat) String() string {
+	switch f {
+	case formatCustom:
+		return "custom"
+	case formatTar:
+		return "tar"
+	case formatDirectory:
+		return "directory"
+	case formatPlain:
+		return "plain"
+	default:
+		return "unknown"
+	}
+}
+
+type compression int
+
+const (
+	compressionNone compression = iota
+	compressionGzip
+	compressionZstd
+	compressionLz4
+	compressionXz
+)
+
+func (c compression) String() string {
+	switch c {
+	case compressionGzip:
+		return "gzip"
+	case compressionZstd:
+		return "zstd"
+	case compressionLz4:
+		return "lz4"
+	case compressionXz:
+		return "xz"
+	default:
+		return "none"
+	}
+}
+
+// compressionMagics are the first bytes of each compressed stream
+var compressionMagics = map[compression][]byte{
+	compressionGzip: {0x1f, 0x8b},
+	compressionZstd: {0x28, 0xb5, 0x2f, 0xfd},
+	compressionLz4:  {0x04, 0x22, 0x4d, 0x18},
+	compressionXz:   {0xfd, 0x37, 0x7a, 0x58, 0x5a, 0x00},
+}
+
+// sniffLength is enough to find pg_dump magic, tar header or a few lines of sql
+const sniffLength = 512
+
+// dumpKind tells how a dump must be read and which tool restores it
+type dumpKind struct {
+	Format      dumpFormat
+	Compression compression
+	Encryption  encryption
+}
+
+func (k dumpKind) String() string {
+	s := k.Format.String()
+	if k.Compression != compressionNone {
+		s += ", " + k.Compression.String()
+	}
+	if k.Encryption != encryptionNone {
+		// Format is only known once decrypted
+		if k.Format == formatUnknown {
+			return fmt.Sprintf("%s encrypted", k.Encryption)
+		}
+		s += ", " + k.Encryption.String()
+	}
+	return s
+}
+
+// plainFile tells if tools can read the dump file as it is, neither compressed nor encrypted
+func (k dumpKind) plainFile() bool {
+	return k.Compression == compressionNone && k.Encryption == encryptionNone
+}
+
+// restorable tells if cappa knows how to restore this kind of dump
+func (k dumpKind) restorable() bool {
+	return k.Format != formatUnknown
+}
+
+// usesPgRestore is true for archives, plain sql is restored with psql
+func (k dumpKind) usesPgRestore() bool {
+	return k.Format == formatCustom || k.Format == formatTar || k.Format == formatDirectory
+}
+
+// sniffFormat guesses the format of uncompressed dump content from its first bytes
+func sniffFormat(head []byte) dumpFormat {
+	if bytes.HasPrefix(head, []byte(archiveMagic)) {
+		return formatCustom
+	}
+	// pg_dump tar archives are ustar and start with toc.dat
+	if len(head) >= 262 && string(head[257:262]) == "ustar" && strings.HasPrefix(string(head), "toc.dat") {
+		return formatTar
+	}
+	if !bytes.Contains(head, []byte{0}) && isText(head) {
+		text := strings.ToUpper(strings.TrimSpace(string(head)))
+		for _, start := range plainSqlStarts {
+			if strings.HasPrefix(text, start) {
+				return formatPlain
+			}
+		}
+	}
+	return formatUnknown
+}
+
+// plainSqlStarts are the ways a sql dump usually begins
+var plainSqlStarts = []string{"--", "/*", "\\", "SET ", "BEGIN", "CREATE ", "DROP ", "SELECT "}
+
+// isText tells if head is utf8, ignoring a multibyte character cut at the end
+func isText(head []byte) bool {
+	for cut := 0; cut < utf8.UTFMax && cut <= len(head); cut++ {
+		if utf8.Valid(head[:len(head)-cut]) {
+			return true
+		}
+	}
+	return false
+}
+
+// detectDump finds format and compression of a dump file or directory
+func detectDump(path string) (dumpKind, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return dumpKind{}, err
+	}
+	if info.IsDir() {
+		if _, err := os.Stat(filepath.Join(path, "toc.dat")); err == nil {
+			return dumpKind{Format: formatDirectory}, nil
+		}
+		return dumpKind{}, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return dumpKind{}, err
+	}
+	kind, content, err := sniffDump(f)
+	if err != nil {
+		f.Close()
+		return kind, err
+	}
+	return kind, content.Close()
+}
+
+// sniffDump detects encryption, compression then format of a dump stream.
+// The returned reader yields decrypted and uncompressed content from its beginning, closing it closes r.
+func sniffDump(r io.ReadCloser) (dumpKind, io.ReadCloser, error) {
+	var kind dumpKind
+
+	encrypted := bufio.NewReaderSize(r, sniffLength)
+	head, err := encrypted.Peek(64)
+	if err != nil && err != io.EOF {
+		return kind, nil, err
+	}
+	kind.Encryption = sniffEncryption(head)
+	r, err = decrypt(&stackedReadCloser{Reader: encrypted, closers: []io.Closer{r}}, kind.Encryption)
+	if err != nil {
+		return kind, nil, err
+	}
+
+	compressed := bufio.NewReaderSize(r, sniffLength)
+	magic, err := compressed.Peek(8)
+	if err != nil && err != io.EOF {
+		r.Close()
+		return kind, nil, err
+	}
+	for c, m := range compressionMagics {
+		if bytes.HasPrefix(magic, m) {
+			kind.Compression = c
+		}
+	}
+
+	raw := &stackedReadCloser{Reader: compressed, closers: []io.Closer{r}}
+	content, err := decompress(raw, kind)
+	if err != nil {
+		// raw was partly read and closed, nothing can be restored from it
+		return kind, nil, fmt.Errorf("dump looks %s compressed but could not be read : %s", kind.Compression, err)
+	}
+	uncompressed := bufio.NewReaderSize(content, sniffLength)
+	head, _ = uncompressed.Peek(sniffLength)
+	kind.Format = sniffFormat(head)
+	return kind, &stackedReadCloser{Reader: uncompressed, closers: []io.Closer{content}}, nil
+}
+
+// openDump returns the decrypted and uncompressed content of a dump file
+func openDump(path string, kind dumpKind) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return decode(f, kind)
+}
+
+// decode decrypts then decompresses r, closing the result closes r
+func decode(r io.ReadCloser, kind dumpKind) (io.ReadCloser, error) {
+	plain, err := decrypt(r, kind.Encryption)
+	if err != nil {
+		return nil, err
+	}
+	return decompress(plain, kind)
+}
+
+// decompress wraps r so it yields uncompressed content, closing the result closes r.
+// Everything is streamed, uncompressed content never touches the disk.
+func decompress(r io.ReadCloser, kind dumpKind) (io.ReadCloser, error) {
+	var decoder io.Reader
+	var closers []io.Closer
+	switch kind.Compression {
+	case compressionNone:
+		return r, nil
+	case compressionGzip:
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+		decoder = zr
+		closers = append(closers, zr)
+	case compressionZstd:
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+		decoder = zr
+		closers = append(closers, closerFunc(func() error { zr.Close(); return nil }))
+	case compressionLz4:
+		decoder = lz4.NewReader(r)
+	case compressionXz:
+		xr, err := xz.NewReader(r)
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+		decoder = xr
+	default:
+		r.Close()
+		return nil, fmt.Errorf("%s compressed dumps are not supported", kind.Compression)
+	}
+	return &stackedReadCloser{Reader: decoder, closers: append(closers, r)}, nil
+}
+
+type closerFunc func() error
+
+func (f closerFunc) Close() error {
+	return f()
+}
+
+// stackedReadCloser reads from a decoder and closes it along with the underlying reader
+type stackedReadCloser struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (s *stackedReadCloser) Close() error {
+	var first error
+	for _, c := range s.closers {
+		if err := c.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
@@ -0,0 +1,151 @@
+package cmd
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+	"github.com/ulikunitz/xz"
+)
+
+func writeDumpFixture(t *testing.T, dir string, name string, content []byte) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func gzipped(content []byte) []byte {
+	var b bytes.Buffer
+	w := gzip.NewWriter(&b)
+	w.Write(content)
+	w.Close()
+	return b.Bytes()
+}
+
+func zstdCompressed(content []byte) []byte {
+	var b bytes.Buffer
+	w, _ := zstd.NewWriter(&b)
+	w.Write(content)
+	w.Close()
+	return b.Bytes()
+}
+
+func lz4Compressed(content []byte) []byte {
+	var b bytes.Buffer
+	w := lz4.NewWriter(&b)
+	w.Write(content)
+	w.Close()
+	return b.Bytes()
+}
+
+func xzCompressed(content []byte) []byte {
+	var b bytes.Buffer
+	w, _ := xz.NewWriter(&b)
+	w.Write(content)
+	w.Close()
+	return b.Bytes()
+}
+
+func Test_DetectDumpFormats(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cappa-formats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	plain := []byte("--\n-- PostgreSQL database dump\n--\n\nSET statement_timeout = 0;\n")
+	custom := []byte("PGDMP\x01\x0e\x00\x04\x08\x01")
+
+	var tarball bytes.Buffer
+	tw := tar.NewWriter(&tarball)
+	tw.WriteHeader(&tar.Header{Name: "toc.dat", Mode: 0600, Size: int64(len(custom)), Format: tar.FormatUSTAR})
+	tw.Write(custom)
+	tw.Close()
+
+	directory := filepath.Join(dir, "prod.dir")
+	os.Mkdir(directory, 0700)
+	writeDumpFixture(t, directory, "toc.dat", custom)
+
+	cases := map[string]dumpKind{
+		writeDumpFixture(t, dir, "prod.sql", plain):                      {Format: formatPlain},
+		writeDumpFixture(t, dir, "prod.sql.gz", gzipped(plain)):          {Format: formatPlain, Compression: compressionGzip},
+		writeDumpFixture(t, dir, "prod.dump", custom):                    {Format: formatCustom},
+		writeDumpFixture(t, dir, "prod.dump.gz", gzipped(custom)):        {Format: formatCustom, Compression: compressionGzip},
+		writeDumpFixture(t, dir, "prod.tar", tarball.Bytes()):            {Format: formatTar},
+		writeDumpFixture(t, dir, "prod.sql.zst", zstdCompressed(plain)):  {Format: formatPlain, Compression: compressionZstd},
+		writeDumpFixture(t, dir, "prod.dump.lz4", lz4Compressed(custom)): {Format: formatCustom, Compression: compressionLz4},
+		writeDumpFixture(t, dir, "prod.dump.xz", xzCompressed(custom)):   {Format: formatCustom, Compression: compressionXz},
+		writeDumpFixture(t, dir, "notes.txt", []byte("\x00\x01binary")):  {},
+		directory: {Format: formatDirectory},
+	}
+	for path, expected := range cases {
+		kind, err := detectDump(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if kind != expected {
+			t.Fatalf("expected %s to be %s, got %s", filepath.Base(path), expected, kind)
+		}
+	}
+
+	broken := writeDumpFixture(t, dir, "broken.sql.gz", []byte("\x1f\x8b\x08\x00"))
+	if _, err := detectDump(broken); err == nil || !strings.Contains(err.Error(), "gzip") {
+		t.Errorf("unreadable compressed dump should be reported, got %v", err)
+	}
+}
+
+func Test_RestorableDumpsSkipsTemporaryFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cappa-picker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeDumpFixture(t, dir, "a.dump", []byte("PGDMP\x01\x0e\x00"))
+	writeDumpFixture(t, dir, "b.sql", []byte("-- dump\n"))
+	writeDumpFixture(t, dir, "cappa-123456", []byte("PGDMP\x01\x0e\x00"))
+	writeDumpFixture(t, dir, "execute.sql", []byte("-- anonymise\n"))
+	writeDumpFixture(t, dir, "readme.md", []byte("\x00"))
+
+	names, _, err := restorableDumps(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 2 || names[0] != "b.sql" || names[1] != "a.dump" {
+		t.Fatalf("unexpected dumps %v", names)
+	}
+}
+
+func Test_DecompressStreamsContent(t *testing.T) {
+	plain := []byte("--\n-- PostgreSQL database dump\n--\n")
+	for c, compressed := range map[compression][]byte{
+		compressionGzip: gzipped(plain),
+		compressionZstd: zstdCompressed(plain),
+		compressionLz4:  lz4Compressed(plain),
+		compressionXz:   xzCompressed(plain),
+	} {
+		kind, content, err := sniffDump(ioutil.NopCloser(bytes.NewReader(compressed)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if kind.Compression != c {
+			t.Fatalf("expected %s, got %s", c, kind.Compression)
+		}
+		got, err := ioutil.ReadAll(content)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, plain) {
+			t.Fatalf("%s content differs : %q", c, got)
+		}
+	}
+}
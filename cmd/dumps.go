@@ -0,0 +1,323 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AlecAivazis/survey/v2"
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+	"github.com/ttacon/chalk"
+	"github.com/xeonx/timeago"
+)
+
+// Unfinished downloads untouched for this long are removed by prune, grab can not resume them anymore after
+const partialDownloadGrace = 24 * time.Hour
+
+var dumpsDirectory string
+var pruneOlderThan, pruneMaxSize string
+var pruneKeep int
+var pruneDryRun, pruneYes bool
+
+// localDump is a restorable dump of the dumps directory
+type localDump struct {
+	Name string
+	Kind dumpKind
+	Size int64
+	// Download time when known, modification time otherwise
+	Time  time.Time
+	Entry *manifestEntry
+}
+
+// localDumps lists restorable dumps of dir with their manifest entry, newest first
+func localDumps(dir string) ([]localDump, *dumpManifest, error) {
+	names, kinds, err := restorableDumps(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	manifest, err := loadManifest(dir)
+	if err != nil {
+		log.Printf("Could not read %s : %s", manifest.path, err)
+	}
+	var dumps []localDump
+	for i, name := range names {
+		info, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			continue
+		}
+		d := localDump{Name: name, Kind: kinds[i], Size: info.Size(), Time: info.ModTime(), Entry: manifest.Dumps[name]}
+		if info.IsDir() {
+			d.Size = directorySize(filepath.Join(dir, name))
+		}
+		if d.Entry != nil && !d.Entry.DownloadedAt.IsZero() {
+			d.Time = d.Entry.DownloadedAt
+		}
+		dumps = append(dumps, d)
+	}
+	// Stable, dumps of the same time keep reverse name order
+	sort.SliceStable(dumps, func(i, j int) bool { return dumps[i].Time.After(dumps[j].Time) })
+	return dumps, manifest, nil
+}
+
+// directorySize adds up sizes of the files of a directory dump
+func directorySize(path string) int64 {
+	var size int64
+	filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+// partialDownloads lists files left by unfinished downloads
+func partialDownloads(dir string) ([]os.FileInfo, error) {
+	matches, err := filepath.Glob(filepath.Join(dir, "cappa-*"))
+	if err != nil {
+		return nil, err
+	}
+	var partials []os.FileInfo
+	for _, m := range matches {
+		if info, err := os.Stat(m); err == nil && info.Mode().IsRegular() {
+			partials = append(partials, info)
+		}
+	}
+	return partials, nil
+}
+
+// prunePolicy tells which dumps to remove, zero values are not checked
+type prunePolicy struct {
+	Before  time.Time
+	Keep    int
+	MaxSize int64
+}
+
+func (p prunePolicy) empty() bool {
+	return p.Before.IsZero() && p.Keep <= 0 && p.MaxSize <= 0
+}
+
+// prunable returns dumps, sorted newest first, which are older than Before, past the Keep newest
+// or past MaxSize once sizes of newer dumps are added up
+func (p prunePolicy) prunable(dumps []localDump) []localDump {
+	var removed []localDump
+	var total int64
+	for i, d := range dumps {
+		total += d.Size
+		switch {
+		case !p.Before.IsZero() && d.Time.Before(p.Before),
+			p.Keep > 0 && i >= p.Keep,
+			p.MaxSize > 0 && total > p.MaxSize:
+			removed = append(removed, d)
+			total -= d.Size
+		}
+	}
+	return removed
+}
+
+var byteSizePattern = regexp.MustCompile(`^(\d+(?:\.\d+)?)\s*([kmgt]?)(?:i?b)?$`)
+
+// parseByteSize reads sizes like 500MB, 10G or 1.5GiB, units are powers of 1024
+func parseByteSize(value string) (int64, error) {
+	m := byteSizePattern.FindStringSubmatch(strings.ToLower(strings.TrimSpace(value)))
+	if m == nil {
+		return 0, fmt.Errorf("invalid size %q, expected e.g. 500MB or 10GB", value)
+	}
+	n, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return 0, err
+	}
+	shift := map[string]uint{"": 0, "k": 10, "m": 20, "g": 30, "t": 40}[m[2]]
+	return int64(n * float64(int64(1)<<shift)), nil
+}
+
+// removeDumps deletes dump files and directories and forgets them in the manifest.
+// A failed removal does not stop the others, the manifest is saved in any case.
+func removeDumps(dir string, manifest *dumpManifest, names []string, out io.Writer) error {
+	var failed error
+	for _, name := range names {
+		if err := os.RemoveAll(filepath.Join(dir, name)); err != nil {
+			log.Printf("Could not remove %s : %s", name, err)
+			if failed == nil {
+				failed = fmt.Errorf("could not remove %s : %s", name, err)
+			}
+			continue
+		}
+		delete(manifest.Dumps, name)
+		fmt.Fprintf(out, "Removed %s\n", name)
+	}
+	manifest.forgetMissing(dir)
+	if err := manifest.save(); err != nil {
+		return err
+	}
+	return failed
+}
+
+// dumpsCmd groups commands managing the local dumps directory, they never need a database
+var dumpsCmd = &cobra.Command{
+	Use:   "dumps",
+	Short: "Manage dumps downloaded in the local directory",
+}
+
+var dumpsListCmd = &cobra.Command{
+	Use:     "list",
+	Aliases: []string{"ls"},
+	Short:   "List local dumps with their origin, download and last restore time",
+	Args:    cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		out := cmd.OutOrStdout()
+		dumps, _, err := localDumps(dumpsDirectory)
+		if err != nil {
+			return err
+		}
+		if len(dumps) == 0 {
+			fmt.Fprintf(out, "No dump found in %s\n", dumpsDirectory)
+		}
+
+		table := tablewriter.NewWriter(out)
+		table.SetHeader([]string{"Name", "Size", "Origin", "Downloaded", "Last restored"})
+		table.SetBorder(false)
+		table.SetAlignment(tablewriter.ALIGN_LEFT)
+		var total int64
+		for _, d := range dumps {
+			total += d.Size
+			origin, restored := "local", "never"
+			if d.Entry != nil {
+				if d.Entry.origin() != "" {
+					origin = d.Entry.origin()
+				}
+				if d.Entry.RestoredAt != nil {
+					restored = timeago.English.Format(*d.Entry.RestoredAt)
+				}
+			}
+			table.Append([]string{d.Name, formatSize(d.Size), origin, timeago.English.Format(d.Time), restored})
+		}
+		table.Render()
+
+		partials, err := partialDownloads(dumpsDirectory)
+		if err != nil {
+			return err
+		}
+		var partialSize int64
+		for _, p := range partials {
+			partialSize += p.Size()
+		}
+		fmt.Fprintf(out, "\n%d dumps, %s", len(dumps), formatSize(total))
+		if len(partials) > 0 {
+			fmt.Fprintf(out, ", %d unfinished download files (%s)", len(partials), formatSize(partialSize))
+		}
+		fmt.Fprintln(out)
+		return nil
+	},
+}
+
+var dumpsPruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Remove old dumps by age, count or total size",
+	Long: `Remove dumps older than --older-than, past the --keep newest ones or past --max-size in total.
+Files of unfinished downloads untouched for a day are removed too.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		out := cmd.OutOrStdout()
+		policy := prunePolicy{Keep: pruneKeep}
+		now := time.Now()
+		var err error
+		if pruneOlderThan != "" {
+			if policy.Before, err = parseSince("--older-than", pruneOlderThan, now); err != nil {
+				return err
+			}
+		}
+		if pruneMaxSize != "" {
+			if policy.MaxSize, err = parseByteSize(pruneMaxSize); err != nil {
+				return err
+			}
+		}
+
+		dumps, manifest, err := localDumps(dumpsDirectory)
+		if err != nil {
+			return err
+		}
+		var names []string
+		if !policy.empty() {
+			for _, d := range policy.prunable(dumps) {
+				names = append(names, d.Name)
+			}
+		}
+		partials, err := partialDownloads(dumpsDirectory)
+		if err != nil {
+			return err
+		}
+		for _, p := range partials {
+			if now.Sub(p.ModTime()) > partialDownloadGrace {
+				names = append(names, p.Name())
+			}
+		}
+
+		if len(names) == 0 {
+			fmt.Fprintln(out, "Nothing to prune")
+			return nil
+		}
+		fmt.Fprintf(out, "%s\n  %s\n", chalk.Yellow.Color("Will remove :"), strings.Join(names, "\n  "))
+		if pruneDryRun {
+			return nil
+		}
+		if !pruneYes {
+			confirmed := false
+			if err := survey.AskOne(&survey.Confirm{Message: fmt.Sprintf("Remove %d files ?", len(names))}, &confirmed); err != nil {
+				return err
+			}
+			if !confirmed {
+				return nil
+			}
+		}
+		return removeDumps(dumpsDirectory, manifest, names, cmd.OutOrStdout())
+	},
+}
+
+var dumpsRmCmd = &cobra.Command{
+	Use:   "rm [name...]",
+	Short: "Remove local dumps, picked in a list when no name is given",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		manifest, err := loadManifest(dumpsDirectory)
+		if err != nil {
+			return err
+		}
+		names := args
+		if len(names) == 0 {
+			name, err := PickFileIn(dumpsDirectory, textOutput())
+			if err != nil {
+				return err
+			}
+			names = []string{name}
+		}
+		for _, name := range names {
+			if filepath.Base(name) != name || strings.HasPrefix(name, ".") {
+				return fmt.Errorf("%s is not a file name of %s", name, dumpsDirectory)
+			}
+			if _, err := os.Stat(filepath.Join(dumpsDirectory, name)); err != nil {
+				return err
+			}
+		}
+		return removeDumps(dumpsDirectory, manifest, names, cmd.OutOrStdout())
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(dumpsCmd)
+	dumpsCmd.AddCommand(dumpsListCmd, dumpsPruneCmd, dumpsRmCmd)
+	dumpsCmd.PersistentFlags().StringVar(&dumpsDirectory, "dir", ".cappa", "Directory where dumps are downloaded")
+
+	dumpsPruneCmd.Flags().StringVar(&pruneOlderThan, "older-than", "", "Remove dumps downloaded before a date (2006-01-02) or a duration ago (36h, 30d)")
+	dumpsPruneCmd.Flags().IntVar(&pruneKeep, "keep", 0, "Keep only this many newest dumps")
+	dumpsPruneCmd.Flags().StringVar(&pruneMaxSize, "max-size", "", "Remove oldest dumps until total size is under this limit (e.g. 10GB)")
+	dumpsPruneCmd.Flags().BoolVar(&pruneDryRun, "dry-run", false, "Only print what would be removed")
+	dumpsPruneCmd.Flags().BoolVarP(&pruneYes, "yes", "y", false, "Do not ask for confirmation")
+}
@@ -0,0 +1,143 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_PrunePolicy(t *testing.T) {
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	// Newest first, like localDumps
+	dumps := []localDump{
+		{Name: "d.dump", Size: 40, Time: now.Add(-time.Hour)},
+		{Name: "c.dump", Size: 30, Time: now.AddDate(0, 0, -2)},
+		{Name: "b.dump", Size: 20, Time: now.AddDate(0, 0, -10)},
+		{Name: "a.dump", Size: 10, Time: now.AddDate(0, 0, -40)},
+	}
+	cases := []struct {
+		policy   prunePolicy
+		expected []string
+	}{
+		{prunePolicy{Before: now.AddDate(0, 0, -7)}, []string{"b.dump", "a.dump"}},
+		{prunePolicy{Keep: 1}, []string{"c.dump", "b.dump", "a.dump"}},
+		{prunePolicy{MaxSize: 80}, []string{"b.dump"}},
+		{prunePolicy{Keep: 3, MaxSize: 70}, []string{"b.dump", "a.dump"}},
+		{prunePolicy{Keep: 10}, nil},
+	}
+	for _, c := range cases {
+		var names []string
+		for _, d := range c.policy.prunable(dumps) {
+			names = append(names, d.Name)
+		}
+		if !reflect.DeepEqual(names, c.expected) {
+			t.Errorf("%+v : expected %v, got %v", c.policy, c.expected, names)
+		}
+	}
+}
+
+func Test_ParseByteSize(t *testing.T) {
+	for value, expected := range map[string]int64{
+		"512":    512,
+		"10kb":   10 << 10,
+		"500MB":  500 << 20,
+		"10G":    10 << 30,
+		"1.5GiB": 3 << 29,
+		"2 TB":   2 << 40,
+	} {
+		size, err := parseByteSize(value)
+		if err != nil || size != expected {
+			t.Errorf("%s : expected %d, got %d (%v)", value, expected, size, err)
+		}
+	}
+	for _, value := range []string{"", "ten", "10PB", "-1G"} {
+		if _, err := parseByteSize(value); err == nil {
+			t.Errorf("expected %q to be rejected", value)
+		}
+	}
+}
+
+func Test_LocalDumpsUsesManifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cappa-dumps")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeDumpFixture(t, dir, "a.dump", []byte("PGDMP\x01\x0e\x00"))
+	writeDumpFixture(t, dir, "b.sql", []byte("-- dump\n"))
+	writeDumpFixture(t, dir, "cappa-b.dump.part", []byte("PGDMP"))
+	// Names sort the other way, a.dump was downloaded last
+	downloaded := time.Now().Add(time.Hour)
+	if err := recordDownload(dir, "a.dump", manifestEntry{Storage: "s3://backups", Key: "db/a.dump", Size: 8, DownloadedAt: downloaded}); err != nil {
+		t.Fatal(err)
+	}
+	if err := recordRestore(dir, "b.sql", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	dumps, manifest, err := localDumps(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dumps) != 2 || dumps[0].Name != "a.dump" || dumps[1].Name != "b.sql" {
+		t.Fatalf("unexpected dumps %v", dumps)
+	}
+	if dumps[0].Entry.origin() != "s3://backups/db/a.dump" || dumps[0].Entry.RestoredAt != nil {
+		t.Errorf("unexpected entry %+v", dumps[0].Entry)
+	}
+	if dumps[1].Entry == nil || dumps[1].Entry.RestoredAt == nil || dumps[1].Entry.Size != 8 {
+		t.Errorf("restore of a local dump not recorded : %+v", dumps[1].Entry)
+	}
+
+	if err := removeDumps(dir, manifest, []string{"a.dump", "cappa-b.dump.part"}, ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+	manifest, err = loadManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := manifest.Dumps["a.dump"]; ok || len(manifest.Dumps) != 1 {
+		t.Errorf("removed dump still in manifest %v", manifest.Dumps)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "cappa-b.dump.part")); !os.IsNotExist(err) {
+		t.Errorf("partial download not removed")
+	}
+}
+
+func Test_DirectoryDumpsAreSizedAndRemoved(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cappa-dumps")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := os.Mkdir(filepath.Join(dir, "app"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	writeDumpFixture(t, dir, "app/toc.dat", []byte("PGDMP\x01\x0e\x00"))
+	writeDumpFixture(t, dir, "app/3001.dat.gz", make([]byte, 1000))
+	if err := recordDownload(dir, "app", manifestEntry{Key: "db/app", Size: 1008}); err != nil {
+		t.Fatal(err)
+	}
+
+	dumps, manifest, err := localDumps(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dumps) != 1 || dumps[0].Size != 1008 {
+		t.Fatalf("directory dump should be sized by its files : %+v", dumps)
+	}
+	if err := removeDumps(dir, manifest, []string{"app"}, ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "app")); !os.IsNotExist(err) {
+		t.Error("directory dump not removed")
+	}
+	if manifest, _ := loadManifest(dir); len(manifest.Dumps) != 0 {
+		t.Errorf("removed dump still in manifest %v", manifest.Dumps)
+	}
+}
@@ -0,0 +1,208 @@
+package cmd
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"filippo.io/age"
+	agearmor "filippo.io/age/armor"
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/openpgp"
+	pgparmor "golang.org/x/crypto/openpgp/armor"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+// useIdentity writes an identity file and configures it as encryption.identity
+func useIdentity(t *testing.T, dir string, content []byte) func() {
+	path := writeDumpFixture(t, dir, "identity.txt", content)
+	viper.Set("encryption.identity", path)
+	return func() { viper.Set("encryption.identity", nil) }
+}
+
+func ageEncrypted(t *testing.T, content []byte, armored bool, recipient age.Recipient) []byte {
+	var out bytes.Buffer
+	var dst io.Writer = &out
+	var armor io.WriteCloser
+	if armored {
+		armor = agearmor.NewWriter(&out)
+		dst = armor
+	}
+	w, err := age.Encrypt(dst, recipient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(content)
+	w.Close()
+	if armor != nil {
+		armor.Close()
+	}
+	return out.Bytes()
+}
+
+func Test_SniffEncryption(t *testing.T) {
+	cases := map[string]encryption{
+		"age-encryption.org/v1\n-> X25519":                     encryptionAge,
+		"-----BEGIN AGE ENCRYPTED FILE-----":                   encryptionAge,
+		"-----BEGIN PGP MESSAGE-----":                          encryptionOpenPGP,
+		"\x85\x01\x0c\x03\x01\x02\x03\x04\x05\x06\x07\x08\x01": encryptionOpenPGP,
+		"\xc1\x4c\x03\x01\x02\x03\x04\x05\x06\x07\x08\x12":     encryptionOpenPGP,
+		"\x8c\x0d\x04\x09\x03":                                 encryptionOpenPGP,
+		"\x85\x01\x0cELF binary":                               encryptionNone,
+		"\xc3\x00\x04\x09\x03":                                 encryptionNone,
+		"\x8f\xff\xff":                                         encryptionNone,
+		"PGDMP\x01\x0e\x00":                                    encryptionNone,
+		"\x1f\x8b\x08":                                         encryptionNone,
+		"-- PostgreSQL database dump":                          encryptionNone,
+	}
+	for head, expected := range cases {
+		if e := sniffEncryption([]byte(head)); e != expected {
+			t.Errorf("%q : expected %s, got %s", head, expected, e)
+		}
+	}
+}
+
+func Test_AgeDumpsAreDecryptedOnTheFly(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cappa-age")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer useIdentity(t, dir, []byte("# created by tests\n"+identity.String()+"\n"))()
+
+	dump := sampleCustomArchive(14)
+	writeDumpFixture(t, dir, "prod.dump.age", ageEncrypted(t, dump, false, identity.Recipient()))
+	writeDumpFixture(t, dir, "prod.dump.zst.age", ageEncrypted(t, zstdCompressed(dump), true, identity.Recipient()))
+
+	for name, expected := range map[string]dumpKind{
+		"prod.dump.age":     {Format: formatCustom, Encryption: encryptionAge},
+		"prod.dump.zst.age": {Format: formatCustom, Compression: compressionZstd, Encryption: encryptionAge},
+	} {
+		input, err := openLocalSource(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if input.Kind != expected {
+			t.Errorf("%s : expected %s, got %s", name, expected, input.Kind)
+		}
+		if input.local() {
+			t.Errorf("%s : encrypted dumps should be decrypted once, as a stream", name)
+		}
+		toc, err := dumpArchiveToc(input)
+		if err != nil {
+			t.Fatalf("%s : %s", name, err)
+		}
+		if toc.Header.Database != "shop" {
+			t.Errorf("%s : unexpected database %q", name, toc.Header.Database)
+		}
+		stream, err := input.stream()
+		if err != nil {
+			t.Fatal(err)
+		}
+		plain, err := ioutil.ReadAll(stream)
+		stream.Close()
+		if err != nil || !bytes.Equal(plain, dump) {
+			t.Errorf("%s : stream differs from plaintext dump (%v)", name, err)
+		}
+	}
+
+	// Listed without being decrypted
+	names, kinds, err := restorableDumps(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 2 || kinds[0].String() != "age encrypted" {
+		t.Errorf("unexpected dumps %v %v", names, kinds)
+	}
+
+	other, _ := age.GenerateX25519Identity()
+	useIdentity(t, dir, []byte(other.String()+"\n"))
+	if _, err := openLocalSource(filepath.Join(dir, "prod.dump.age")); err != errNoDecryptionKey {
+		t.Errorf("expected missing key error, got %v", err)
+	}
+}
+
+func Test_PlainDumpsIgnoreEncryptionIdentities(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cappa-plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	viper.Set("encryption.identity", filepath.Join(dir, "missing.agekey"))
+	defer viper.Set("encryption.identity", nil)
+	writeDumpFixture(t, dir, "prod.dump", sampleCustomArchive(14))
+
+	names, _, err := restorableDumps(dir)
+	if err != nil || len(names) != 1 {
+		t.Errorf("unreadable identity should not hide plain dumps, got %v (%v)", names, err)
+	}
+	if _, err := openLocalSource(filepath.Join(dir, "prod.dump")); err != nil {
+		t.Error(err)
+	}
+}
+
+func Test_OpenPGPDumpsAreDecryptedFromStreams(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cappa-pgp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	entity, err := openpgp.NewEntity("Backups", "", "backups@example.com", &packet.Config{RSABits: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Default preferences ask for RIPEMD160 which is not compiled in
+	for _, id := range entity.Identities {
+		id.SelfSignature.PreferredHash = []uint8{8}
+	}
+	var key bytes.Buffer
+	w, _ := pgparmor.Encode(&key, openpgp.PrivateKeyType, nil)
+	if err := entity.SerializePrivate(w, nil); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	defer useIdentity(t, dir, key.Bytes())()
+
+	plain := []byte("--\n-- PostgreSQL database dump\n--\nCREATE TABLE users (id int);\n")
+	var encrypted bytes.Buffer
+	armored, _ := pgparmor.Encode(&encrypted, "PGP MESSAGE", nil)
+	pw, err := openpgp.Encrypt(armored, []*openpgp.Entity{entity}, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pw.Write(gzipped(plain))
+	pw.Close()
+	armored.Close()
+
+	kind, content, err := sniffDump(ioutil.NopCloser(&encrypted))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer content.Close()
+	expected := dumpKind{Format: formatPlain, Compression: compressionGzip, Encryption: encryptionOpenPGP}
+	if kind != expected {
+		t.Errorf("expected %s, got %s", expected, kind)
+	}
+	got, err := ioutil.ReadAll(content)
+	if err != nil || !bytes.Equal(got, plain) {
+		t.Errorf("decrypted content differs (%v) : %q", err, got)
+	}
+	// Binary messages are recognized by their session key packet, for a key or a passphrase
+	var binary, symmetric bytes.Buffer
+	pw, _ = openpgp.Encrypt(&binary, []*openpgp.Entity{entity}, nil, nil, nil)
+	pw.Close()
+	pw, _ = openpgp.SymmetricallyEncrypt(&symmetric, []byte("secret"), nil, nil)
+	pw.Close()
+	for _, message := range [][]byte{binary.Bytes(), symmetric.Bytes()} {
+		if e := sniffEncryption(message[:64]); e != encryptionOpenPGP {
+			t.Errorf("binary message %x not recognized", message[:8])
+		}
+	}
+}
@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"github.com/spf13/cobra"
 	"github.com/ttacon/chalk"
+	"io"
 	"io/ioutil"
 	"log"
 	"os"
@@ -49,43 +50,30 @@ a dump file from production
 Write sql statements in a file (default '.cappa.sql') separate by newline and run this command.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		log.Println("Command `execute` called")
+		out := cmd.OutOrStdout()
 		sqlFileName := "execute.sql"
 
 		// open sql file
 		dat, err := ioutil.ReadFile(path.Join(".cappa", sqlFileName))
 		if os.IsNotExist(err) {
-			fmt.Println(chalk.Bold.TextStyle("File does not exists, create a .cappa/execute.sql file"))
+			fmt.Fprintln(out, chalk.Bold.TextStyle("File does not exists, create a .cappa/execute.sql file"))
 			os.Exit(0)
 		}
 		if err != nil {
-			fmt.Println("Error : Could not open sql file")
+			fmt.Fprintln(out, "Error : Could not open sql file")
 			log.Fatal(err)
 		}
 
-		//Split content at newline
-		Sqls := strings.Split(string(dat), "\n")
-
-		// Create connection to primary database
-		primaryConn := createConnection(trackedDbUrl)
-		defer primaryConn.Close(context.Background())
-
-		executionCount := 0
-		for _, sql := range Sqls {
-			if sql == "" {
-				continue
-			}
-			log.Printf("Execute : %s", sql)
-
-			_, err = primaryConn.Exec(context.Background(), sql)
-			if err != nil {
-				fmt.Printf("Error executing the statement : %s", err)
-			}
-			executionCount += 1
+		executionCount, failedCount, err := executeStatements(trackedDbUrl, string(dat), out)
+		if err != nil {
+			log.Fatal(err)
 		}
-		if executionCount > 0 {
-			fmt.Println(chalk.Green.Color("All statements executed"))
+		if failedCount > 0 {
+			fmt.Fprintln(out, chalk.Yellow.Color(fmt.Sprintf("%d of %d statements failed", failedCount, executionCount)))
+		} else if executionCount > 0 {
+			fmt.Fprintln(out, chalk.Green.Color("All statements executed"))
 		} else {
-			fmt.Println(chalk.Yellow.Color("Nothing done, file empty ?"))
+			fmt.Fprintln(out, chalk.Yellow.Color("Nothing done, file empty ?"))
 		}
 
 	},
@@ -104,3 +92,27 @@ func init() {
 	// is called directly, e.g.:
 	// executeCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
 }
+
+// executeStatements runs sql separated by newlines on database, one statement per line.
+// Failed statements are printed to out and skipped, they are counted apart.
+func executeStatements(connUrl string, sqls string, out io.Writer) (executed int, failed int, err error) {
+	conn, err := connect(connUrl)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer conn.Close(context.Background())
+
+	for _, sql := range strings.Split(sqls, "\n") {
+		if sql == "" {
+			continue
+		}
+		log.Printf("Execute : %s", sql)
+
+		if _, err := conn.Exec(context.Background(), sql); err != nil {
+			fmt.Fprintf(out, "Error executing the statement : %s\n", redact(err.Error()))
+			failed++
+		}
+		executed++
+	}
+	return executed, failed, nil
+}
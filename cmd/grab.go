@@ -23,73 +23,165 @@ import (
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
-	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"github.com/spf13/viper"
-	"gopkg.in/cheggaaa/pb.v1"
-	"io"
-	"io/ioutil"
+	"github.com/xeonx/timeago"
 	"log"
 	"os"
 	"path/filepath"
 	"sort"
+	"strings"
 	"time"
 
 	"github.com/spf13/cobra"
 )
 
-type S3Key struct {
+type remoteObject struct {
 	key     string
 	updated time.Time
 	size    int64
+	// Set when listing object versions, latest is the current version of key
+	versionId string
+	latest    bool
+	// Changes with the content of the object, md5 is hex and only set when the storage knows it
+	etag string
+	md5  string
+}
+
+// localName is the file name of a downloaded key, previous versions get their date so they do not
+// replace the current one, e.g. latest.2026-10-12T080000.dump
+func (k remoteObject) localName() string {
+	name := filepath.Base(k.key)
+	if k.versionId == "" || k.latest {
+		return name
+	}
+	stamp := k.updated.UTC().Format("2006-01-02T150405")
+	if i := strings.Index(name, "."); i > 0 {
+		return name[:i] + "." + stamp + name[i:]
+	}
+	return name + "." + stamp
+}
+
+func (k remoteObject) String() string {
+	s := fmt.Sprintf("%s  (%s, %s", k.key, formatSize(k.size), timeago.English.Format(k.updated))
+	if k.versionId != "" {
+		s += ", version " + k.versionId
+		if k.latest {
+			s += ", latest"
+		}
+	}
+	return s + ")"
 }
 
 type AwsConfig struct {
 	AwsAccessKeyId     string `mapstructure:"aws_access_key_id"`
 	AwsSecretAccessKey string `mapstructure:"aws_secret_access_key"`
-	Dest               string `mapstructure:"destination"`
-	Bucket             string `mapstructure:"bucket"`
-	Region             string `mapstructure:"region"`
-	Prefix             string `mapstructure:"prefix"`
+	AwsSessionToken    string `mapstructure:"aws_session_token"`
+	// Named profile of ~/.aws/config and ~/.aws/credentials, wins over keys
+	Profile string `mapstructure:"aws_profile"`
+	// Role assumed with the credentials above, e.g. in a backups account
+	RoleArn         string `mapstructure:"role_arn"`
+	ExternalId      string `mapstructure:"external_id"`
+	MfaSerial       string `mapstructure:"mfa_serial"`
+	RoleSessionName string `mapstructure:"role_session_name"`
+	// STS endpoint when it is not the one of S3 (local stand-ins)
+	StsEndpoint string `mapstructure:"sts_endpoint"`
+	Dest        string `mapstructure:"destination"`
+	Bucket      string `mapstructure:"bucket"`
+	Region      string `mapstructure:"region"`
+	Prefix      string `mapstructure:"prefix"`
+	// S3 compatible storage (MinIO, Ceph, R2, localstack)
+	Endpoint       string `mapstructure:"endpoint"`
+	ForcePathStyle bool   `mapstructure:"force_path_style"`
+	DisableSSL     bool   `mapstructure:"disable_ssl"`
 }
 
+var grabMatch, grabRegex, grabSince, grabAt string
+var grabLatest, grabWhoami, grabVersions bool
+
 // grabCmd represents the grab command
 var grabCmd = &cobra.Command{
 	Use:   "grab",
-	Short: "Grab backup file (.dump) from s3 bucket",
-	Long:  `Grab list all files in bucket and allow you to pick to download.`,
+	Short: "Grab backup file (.dump) from s3 bucket or another storage",
+	Long: `Grab list all files in bucket and allow you to pick to download.
+Named sources of the config file ([sources.<name>]) can be in s3, Google Cloud Storage,
+sftp, http directory indexes or local directories.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 
-		awsconfig := AwsConfig{
-			AwsSecretAccessKey: viper.GetString("aws_secret_access_key"),
-			AwsAccessKeyId:     viper.GetString("aws_access_key_id"),
-			Dest:               viper.GetString("dest"),
-			Bucket:             viper.GetString("bucket"),
-			Region:             viper.GetString("region"),
-			Prefix:             viper.GetString("prefix"),
+		// A named source of the config file, or the bucket given by flags
+		var source sourceConfig
+		var err error
+		if name := viper.GetString("source"); name != "" {
+			source, err = loadSource(name)
+		} else if grabWhoami {
+			source = sourceConfig{Aws: awsConfigFromViper()}
+		} else {
+			source, err = bucketSource()
+		}
+		if err != nil {
+			return err
 		}
 
-		// Create AWS session
-		sess := getAwsSession(&awsconfig)
+		if grabWhoami {
+			if source.Url != "" && !strings.HasPrefix(source.Url, "s3://") {
+				return fmt.Errorf("--whoami only applies to S3 sources")
+			}
+			sess, err := getAwsSession(&source.Aws)
+			if err != nil {
+				return err
+			}
+			return printAwsIdentity(cmd.OutOrStdout(), sess, source.Aws)
+		}
 
-		if awsconfig.Bucket == "" {
-			return fmt.Errorf("You must provide a bucket value")
+		store, prefix, err := openStorage(source)
+		if err != nil {
+			return err
 		}
-		if awsconfig.Region == "" {
-			return fmt.Errorf("You must provide a region value")
+		defer store.Close()
+
+		if grabMatch == "" && grabRegex == "" {
+			grabMatch, grabRegex = source.Match, source.Regex
 		}
-		// Grab a list of filenames from source s3
-		backupList, err := readBucket(awsconfig.Bucket, awsconfig.Prefix, sess)
+		filter, err := newKeyFilter(grabMatch, grabRegex, grabSince, grabAt, time.Now())
 		if err != nil {
-			log.Printf("Error listing files in bucket : %s", err)
+			return err
 		}
 
-		// Ask user to select one file in list
-		filekey, filename, filesize := selectBackupIn(backupList)
+		// Grab a list of filenames, or of their versions, from source storage
+		var backupList []remoteObject
+		if grabVersions {
+			versioned, ok := store.(versionedStorage)
+			if !ok {
+				return fmt.Errorf("%s does not keep versions of objects", store)
+			}
+			backupList, err = versioned.ListVersions(prefix)
+		} else {
+			backupList, err = store.List(prefix)
+		}
+		if err != nil {
+			return fmt.Errorf("Error listing files in %s : %s", store, err)
+		}
+		backupList = filter.apply(backupList)
+		if len(backupList) == 0 {
+			return fmt.Errorf("No dump found in %s matching filters", store)
+		}
 
-		if filekey != "" {
+		// Ask user to select one file in list, list is sorted newest first
+		var backup remoteObject
+		if grabLatest {
+			backup = backupList[0]
+			fmt.Fprintf(cmd.OutOrStdout(), "Latest dump is %s\n", backup)
+		} else {
+			backup = selectBackupIn(backupList)
+		}
+
+		if backup.key != "" {
+			dest := viper.GetString("dest")
+			if source.Dest != "" {
+				dest = source.Dest
+			}
 			// Create backups directory if not exists
-			_ = os.Mkdir(awsconfig.Dest, 0700)
-			err := Download(awsconfig.Bucket, sess, filekey, filename, filesize, awsconfig.Dest)
+			_ = os.Mkdir(dest, 0700)
+			err := Download(store, backup, backup.localName(), dest, cmd.OutOrStdout())
 			if err != nil {
 				log.Fatalf("Could not download file : %s", err)
 			}
@@ -108,161 +200,205 @@ func init() {
 	grabCmd.PersistentFlags().String("bucket", "", "Aws s3 bucket")
 	grabCmd.PersistentFlags().String("region", "", "Aws s3 region")
 	grabCmd.PersistentFlags().String("prefix", "", "Prefix, within bucket, where to look for backup files")
+	grabCmd.PersistentFlags().String("endpoint", "", "S3 compatible endpoint, e.g. http://localhost:9000 for MinIO")
+	grabCmd.PersistentFlags().Bool("force_path_style", false, "Use bucket in path (endpoint/bucket/key) instead of subdomain, needed by most S3 compatible storages")
+	grabCmd.PersistentFlags().Bool("disable_ssl", false, "Use http to reach endpoint")
+	grabCmd.PersistentFlags().String("aws_profile", "", "Named AWS profile, from ~/.aws/config and ~/.aws/credentials")
+	grabCmd.PersistentFlags().String("role_arn", "", "Role to assume before reaching the bucket")
+	grabCmd.PersistentFlags().String("external_id", "", "External ID required to assume the role")
+	grabCmd.PersistentFlags().String("mfa_serial", "", "MFA device (serial or arn) required to assume the role, code is asked")
+	grabCmd.PersistentFlags().String("source", "", "Named source of the config file ([sources.<name>]), or a s3://, gs://, sftp://, http(s):// url or a directory")
+
+	grabCmd.Flags().StringVar(&grabMatch, "match", "", "Only keys matching this glob pattern, on whole key or file name (e.g. '*.dump')")
+	grabCmd.Flags().StringVar(&grabRegex, "regex", "", "Only keys matching this regular expression")
+	grabCmd.Flags().StringVar(&grabSince, "since", "", "Only dumps modified since a date (2006-01-02) or a duration (36h, 7d)")
+	grabCmd.Flags().StringVar(&grabAt, "at", "", "Only dumps modified on this day (2006-01-02)")
+	grabCmd.Flags().BoolVar(&grabLatest, "latest", false, "Download the newest matching dump without asking")
+	grabCmd.Flags().BoolVar(&grabWhoami, "whoami", false, "Show which AWS identity and credentials are used, then exit")
+	grabCmd.Flags().BoolVar(&grabVersions, "versions", false, "List every version of objects in a versioned bucket, to pick a previous one")
+
+	bindFlag("aws_access_key_id", grabCmd.PersistentFlags().Lookup("aws_access_key_id"))
+	bindFlag("aws_secret_access_key", grabCmd.PersistentFlags().Lookup("aws_secret_access_key"))
+	bindFlag("dest", grabCmd.PersistentFlags().Lookup("dest"))
+	bindFlag("bucket", grabCmd.PersistentFlags().Lookup("bucket"))
+	bindFlag("region", grabCmd.PersistentFlags().Lookup("region"))
+	bindFlag("prefix", grabCmd.PersistentFlags().Lookup("prefix"))
+	bindFlag("endpoint", grabCmd.PersistentFlags().Lookup("endpoint"))
+	bindFlag("force_path_style", grabCmd.PersistentFlags().Lookup("force_path_style"))
+	bindFlag("disable_ssl", grabCmd.PersistentFlags().Lookup("disable_ssl"))
+	bindFlag("aws_profile", grabCmd.PersistentFlags().Lookup("aws_profile"))
+	bindFlag("role_arn", grabCmd.PersistentFlags().Lookup("role_arn"))
+	bindFlag("external_id", grabCmd.PersistentFlags().Lookup("external_id"))
+	bindFlag("mfa_serial", grabCmd.PersistentFlags().Lookup("mfa_serial"))
+	bindFlag("source", grabCmd.PersistentFlags().Lookup("source"))
 
-	viper.BindPFlag("aws_access_key_id", grabCmd.PersistentFlags().Lookup("aws_access_key_id"))
-	viper.BindPFlag("aws_secret_access_key", grabCmd.PersistentFlags().Lookup("aws_secret_access_key"))
-	viper.BindPFlag("dest", grabCmd.PersistentFlags().Lookup("dest"))
-	viper.BindPFlag("bucket", grabCmd.PersistentFlags().Lookup("bucket"))
-	viper.BindPFlag("region", grabCmd.PersistentFlags().Lookup("region"))
-	viper.BindPFlag("prefix", grabCmd.PersistentFlags().Lookup("prefix"))
+}
 
+// awsConfigFromViper reads aws settings from flags, env and config file
+func awsConfigFromViper() AwsConfig {
+	awsconfig := AwsConfig{
+		AwsSecretAccessKey: viper.GetString("aws_secret_access_key"),
+		AwsAccessKeyId:     viper.GetString("aws_access_key_id"),
+		AwsSessionToken:    viper.GetString("aws_session_token"),
+		Profile:            viper.GetString("aws_profile"),
+		RoleArn:            viper.GetString("role_arn"),
+		ExternalId:         viper.GetString("external_id"),
+		MfaSerial:          viper.GetString("mfa_serial"),
+		RoleSessionName:    viper.GetString("role_session_name"),
+		StsEndpoint:        viper.GetString("sts_endpoint"),
+		Dest:               viper.GetString("dest"),
+		Bucket:             viper.GetString("bucket"),
+		Region:             viper.GetString("region"),
+		Prefix:             viper.GetString("prefix"),
+		Endpoint:           viper.GetString("endpoint"),
+		ForcePathStyle:     viper.GetBool("force_path_style"),
+		DisableSSL:         viper.GetBool("disable_ssl"),
+	}
+	// Region of the AWS cli environment, keys like AWS_PROFILE or AWS_SESSION_TOKEN are read as they are
+	for _, env := range []string{"AWS_REGION", "AWS_DEFAULT_REGION"} {
+		if awsconfig.Region == "" {
+			awsconfig.Region = os.Getenv(env)
+		}
+	}
+	// Most S3 compatible storages ignore region but the sdk needs one to sign requests
+	if awsconfig.Endpoint != "" && awsconfig.Region == "" {
+		awsconfig.Region = "us-east-1"
+	}
+	return awsconfig
 }
 
 // Read bucket content an return a list of s3 Keys
-func readBucket(bucket string, prefix string, sess *session.Session) ([]S3Key, error) {
+func readBucket(bucket string, prefix string, sess *session.Session) ([]remoteObject, error) {
 
 	// Create S3 service client
 	svc := s3.New(sess)
 
-	params := &s3.ListObjectsInput{
+	params := &s3.ListObjectsV2Input{
 		Bucket: aws.String(bucket),
 		Prefix: aws.String(prefix),
 	}
 
-	resp, listError := svc.ListObjects(params)
+	// A page holds at most 1000 keys, go through all of them
+	var keyList []remoteObject
+	listError := svc.ListObjectsV2Pages(params, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, key := range page.Contents {
+			etag := aws.StringValue(key.ETag)
+			keyList = append(keyList, remoteObject{key: *key.Key, updated: *key.LastModified, size: *key.Size, etag: etag, md5: md5OfEtag(etag)})
+		}
+		return true
+	})
 	if listError != nil {
 		return nil, listError
 	}
 
-	var keyList []S3Key
-	for _, key := range resp.Contents {
-		keyList = append(keyList, S3Key{*key.Key, *key.LastModified, *key.Size})
-	}
-
 	// Sort slice of keys by last modified date first
 	sort.Slice(keyList, func(i, j int) bool { return keyList[i].updated.After(keyList[j].updated) })
 
 	return keyList, nil
 }
 
-func getAwsSession(aco *AwsConfig) *session.Session {
-
-	var config *aws.Config
-
-	config = &aws.Config{
-		Region:      aws.String(aco.Region),
-		Credentials: credentials.NewStaticCredentials(aco.AwsAccessKeyId, aco.AwsSecretAccessKey, ""),
+// readVersions lists every version of objects under prefix, newest first. Deleted objects are skipped,
+// their previous versions are kept.
+func readVersions(bucket string, prefix string, sess *session.Session) ([]remoteObject, error) {
+	svc := s3.New(sess)
+	params := &s3.ListObjectVersionsInput{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
 	}
 
-	if aco.AwsAccessKeyId != "" && aco.AwsSecretAccessKey != "" {
-		log.Println("Found AWS credentials from env, flags or config file, using them")
-		// Initialize a session with credentials from config or ENV (not from ~/.aws/credentials)
-		config = &aws.Config{
-			Region:      aws.String(aco.Region),
-			Credentials: credentials.NewStaticCredentials(aco.AwsAccessKeyId, aco.AwsSecretAccessKey, ""),
+	var keyList []remoteObject
+	err := svc.ListObjectVersionsPages(params, func(page *s3.ListObjectVersionsOutput, lastPage bool) bool {
+		for _, v := range page.Versions {
+			keyList = append(keyList, remoteObject{
+				key:       aws.StringValue(v.Key),
+				updated:   aws.TimeValue(v.LastModified),
+				size:      aws.Int64Value(v.Size),
+				versionId: aws.StringValue(v.VersionId),
+				latest:    aws.BoolValue(v.IsLatest),
+				etag:      aws.StringValue(v.ETag),
+				md5:       md5OfEtag(aws.StringValue(v.ETag)),
+			})
 		}
-	} else {
-		log.Println("Using shared AWS credentials like ~/.aws/credentials")
-		// Initialize a session with credentials from the shared credentials file ~/.aws/credentials.
-		config = &aws.Config{
-			Region: aws.String(aco.Region),
-		}
-	}
-
-	sess, err := session.NewSession(
-		config,
-	)
+		return true
+	})
 	if err != nil {
-		panic(fmt.Sprintln("Error while creating session"))
+		return nil, err
 	}
 
-	return sess
+	sort.SliceStable(keyList, func(i, j int) bool { return keyList[i].updated.After(keyList[j].updated) })
+	return keyList, nil
 }
 
-func selectBackupIn(backupList []S3Key) (key string, filename string, size int64) {
-	var Selector []string
+// getAwsSession creates a session with, in order : a named profile, keys from flags, env or config file,
+// then the default chain (env, web identity, ~/.aws/credentials, instance role). role_arn is assumed on top.
+func getAwsSession(aco *AwsConfig) (*session.Session, error) {
 
-	for _, backup := range backupList {
-		//fmt.Printf("%s | %d\n", backup.key, backup.size)
-		Selector = append(Selector, backup.key)
-	}
-	backupKey := ""
-	prompt := &survey.Select{
-		Message: "Select backup file:",
-		Options: Selector,
+	config := &aws.Config{}
+	if aco.Region != "" {
+		config.Region = aws.String(aco.Region)
 	}
-	err := survey.AskOne(prompt, &backupKey, nil)
-	if err == terminal.InterruptErr {
-		fmt.Println("User terminated prompt")
-		os.Exit(0)
-	} else if err != nil {
-		panic(err)
+	options := session.Options{
+		// Profiles of ~/.aws/config can assume roles, with MFA too
+		SharedConfigState:       session.SharedConfigEnable,
+		AssumeRoleTokenProvider: mfaTokenProvider(aco.MfaSerial),
 	}
 
-	backupFilename := filepath.Base(backupKey)
-	var backupSize int64
-	for _, v := range backupList {
-		if v.key == backupKey {
-			backupSize = v.size
-		}
+	switch {
+	case aco.Profile != "":
+		log.Printf("Using AWS profile %s", aco.Profile)
+		options.Profile = aco.Profile
+	case aco.AwsAccessKeyId != "" && aco.AwsSecretAccessKey != "":
+		log.Println("Found AWS credentials from env, flags or config file, using them")
+		config.Credentials = credentials.NewStaticCredentials(aco.AwsAccessKeyId, aco.AwsSecretAccessKey, aco.AwsSessionToken)
+	default:
+		log.Println("Using default AWS credentials : env, web identity, ~/.aws/credentials or instance role")
 	}
 
-	return backupKey, backupFilename, backupSize
-}
-
-type progressWriter struct {
-	writer io.WriterAt
-	pb     *pb.ProgressBar
-}
-
-func (pw *progressWriter) WriteAt(p []byte, off int64) (int, error) {
-	pw.pb.Add(len(p))
-	return pw.writer.WriteAt(p, off)
-}
-
-// Download downloads a file to the local filesystem using s3downloader
-func Download(bucket string, sess *session.Session, filekey string, filename string, filesize int64, destination string) error {
+	if aco.Endpoint != "" {
+		log.Printf("Using S3 compatible endpoint %s", aco.Endpoint)
+		config.Endpoint = aws.String(aco.Endpoint)
+	}
+	config.S3ForcePathStyle = aws.Bool(aco.ForcePathStyle)
+	config.DisableSSL = aws.Bool(aco.DisableSSL)
 
-	temp, err := ioutil.TempFile(destination, "cappa-")
+	options.Config = *config
+	sess, err := session.NewSessionWithOptions(options)
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("Error while creating session : %s", err)
 	}
 
-	bar := pb.New64(filesize).SetUnits(pb.U_BYTES)
-	bar.Start()
-
-	writer := &progressWriter{writer: temp, pb: bar}
-
-	tempfileName := temp.Name()
-
-	params := &s3.GetObjectInput{
-		Bucket: aws.String(bucket),
-		Key:    aws.String(filekey),
+	if aco.RoleArn != "" {
+		sess = assumeRole(sess, aco)
 	}
+	return sess, nil
+}
 
-	downloader := s3manager.NewDownloader(sess)
+func selectBackupIn(backupList []remoteObject) remoteObject {
+	var Selector []string
 
-	if _, err := downloader.Download(writer, params); err != nil {
-		bar.Set64(bar.Total)
-		return err
+	for _, backup := range backupList {
+		Selector = append(Selector, backup.String())
 	}
-
-	bar.FinishPrint(fmt.Sprintf("Downloaded %s to %s", filename, destination))
-
-	if err := temp.Close(); err != nil {
+	backupSelected := ""
+	prompt := &survey.Select{
+		Message:  "Select backup file (type to search):",
+		Options:  Selector,
+		PageSize: 15,
+	}
+	err := survey.AskOne(prompt, &backupSelected, survey.WithFilter(func(filter string, value string, index int) bool {
+		return fuzzyMatch(filter, value)
+	}))
+	if err == terminal.InterruptErr {
+		fmt.Fprintln(textOutput(), "User terminated prompt")
+		os.Exit(0)
+	} else if err != nil {
 		panic(err)
 	}
 
-	defer func() {
-		err := os.Remove(tempfileName)
-		if err != nil {
-			log.Printf("Could not remove temp file")
+	for i, option := range Selector {
+		if option == backupSelected {
+			return backupList[i]
 		}
-	}()
-
-	if err := os.Rename(temp.Name(), fmt.Sprintf("%s/%s", destination, filename)); err != nil {
-		panic(err)
 	}
-
-	return nil
+	return remoteObject{}
 }
@@ -0,0 +1,106 @@
+package cmd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Keys are listed in two pages, like a bucket with more than 1000 keys
+var listBucketPages = map[string]string{
+	"": `<?xml version="1.0" encoding="UTF-8"?>
+<ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/">
+  <Name>backups</Name>
+  <Prefix>db/</Prefix>
+  <IsTruncated>true</IsTruncated>
+  <NextContinuationToken>page2</NextContinuationToken>
+  <Contents><Key>db/monday.dump</Key><LastModified>2020-10-05T08:00:00.000Z</LastModified><Size>1024</Size></Contents>
+</ListBucketResult>`,
+	"page2": `<?xml version="1.0" encoding="UTF-8"?>
+<ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/">
+  <Name>backups</Name>
+  <Prefix>db/</Prefix>
+  <IsTruncated>false</IsTruncated>
+  <Contents><Key>db/tuesday.dump</Key><LastModified>2020-10-06T08:00:00.000Z</LastModified><Size>2048</Size></Contents>
+</ListBucketResult>`,
+}
+
+func Test_ReadBucketPagesFromS3CompatibleEndpoint(t *testing.T) {
+	var path string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		w.Header().Set("Content-Type", "application/xml")
+		w.Write([]byte(listBucketPages[r.URL.Query().Get("continuation-token")]))
+	}))
+	defer server.Close()
+
+	awsconfig := AwsConfig{
+		AwsAccessKeyId:     "minioadmin",
+		AwsSecretAccessKey: "minioadmin",
+		Region:             "us-east-1",
+		Endpoint:           server.URL,
+		ForcePathStyle:     true,
+		DisableSSL:         true,
+	}
+	sess, err := getAwsSession(&awsconfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := readBucket("backups", "db/", sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != "/backups" {
+		t.Errorf("bucket should be in path with force_path_style, got %q", path)
+	}
+	if len(keys) != 2 || keys[0].key != "db/tuesday.dump" {
+		t.Errorf("unexpected keys %+v", keys)
+	}
+}
+
+const listVersionsPage = `<?xml version="1.0" encoding="UTF-8"?>
+<ListVersionsResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/">
+  <Name>backups</Name>
+  <Prefix>db/</Prefix>
+  <IsTruncated>false</IsTruncated>
+  <DeleteMarker><Key>db/old.dump</Key><VersionId>d1</VersionId><IsLatest>true</IsLatest><LastModified>2020-10-07T08:00:00.000Z</LastModified></DeleteMarker>
+  <Version><Key>db/latest.dump</Key><VersionId>v2</VersionId><IsLatest>true</IsLatest><LastModified>2020-10-06T08:00:00.000Z</LastModified><Size>2048</Size></Version>
+  <Version><Key>db/latest.dump</Key><VersionId>v1</VersionId><IsLatest>false</IsLatest><LastModified>2020-10-05T08:00:00.000Z</LastModified><Size>1024</Size></Version>
+  <Version><Key>db/old.dump</Key><VersionId>o1</VersionId><IsLatest>false</IsLatest><LastModified>2020-10-01T08:00:00.000Z</LastModified><Size>512</Size></Version>
+</ListVersionsResult>`
+
+func Test_ReadVersionsSkipsDeleteMarkers(t *testing.T) {
+	var query string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.RawQuery
+		w.Header().Set("Content-Type", "application/xml")
+		w.Write([]byte(listVersionsPage))
+	}))
+	defer server.Close()
+
+	awsconfig := AwsConfig{AwsAccessKeyId: "key", AwsSecretAccessKey: "secret", Region: "us-east-1", Endpoint: server.URL, ForcePathStyle: true, DisableSSL: true}
+	sess, err := getAwsSession(&awsconfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := readVersions("backups", "db/", sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(query, "versions") {
+		t.Errorf("expected a ListObjectVersions request, got %q", query)
+	}
+	if len(keys) != 3 {
+		t.Fatalf("unexpected versions %+v", keys)
+	}
+	names := []string{keys[0].localName(), keys[1].localName(), keys[2].localName()}
+	expected := []string{"latest.dump", "latest.2020-10-05T080000.dump", "old.2020-10-01T080000.dump"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected %v, got %v", expected, names)
+	}
+	if keys[1].versionId != "v1" || keys[1].latest {
+		t.Errorf("unexpected previous version %+v", keys[1])
+	}
+}
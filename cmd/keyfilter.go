@@ -0,0 +1,132 @@
+package cmd
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Layouts accepted by --since and --at, in local time unless a zone is given
+var dateLayouts = []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02 15:04", "2006-01-02"}
+
+// keyFilter selects bucket keys by name and last modification date
+type keyFilter struct {
+	Glob  string
+	Regex *regexp.Regexp
+	// Zero times are not checked
+	Since time.Time
+	Until time.Time
+}
+
+// newKeyFilter builds a filter from --match, --regex, --since and --at values
+func newKeyFilter(glob string, expr string, since string, at string, now time.Time) (keyFilter, error) {
+	f := keyFilter{Glob: glob}
+	if glob != "" {
+		if _, err := path.Match(glob, ""); err != nil {
+			return f, fmt.Errorf("invalid --match pattern %q : %s", glob, err)
+		}
+	}
+	if expr != "" {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return f, fmt.Errorf("invalid --regex %q : %s", expr, err)
+		}
+		f.Regex = re
+	}
+	if since != "" {
+		t, err := parseSince("--since", since, now)
+		if err != nil {
+			return f, err
+		}
+		f.Since = t
+	}
+	if at != "" {
+		day, err := parseDate(at)
+		if err != nil {
+			return f, err
+		}
+		day = time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, day.Location())
+		if day.After(f.Since) {
+			f.Since = day
+		}
+		f.Until = day.AddDate(0, 0, 1)
+	}
+	return f, nil
+}
+
+// parseSince reads a date or a duration before now like 36h, 30m or 7d, flag names the option in errors
+func parseSince(flag string, value string, now time.Time) (time.Time, error) {
+	if strings.HasSuffix(value, "d") {
+		if days, err := strconv.Atoi(strings.TrimSuffix(value, "d")); err == nil {
+			return now.AddDate(0, 0, -days), nil
+		}
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(-d), nil
+	}
+	t, err := parseDate(value)
+	if err != nil {
+		return t, fmt.Errorf("%s must be a date (2006-01-02) or a duration (36h, 7d), not %q", flag, value)
+	}
+	return t, nil
+}
+
+func parseDate(value string) (time.Time, error) {
+	for _, layout := range dateLayouts {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q, expected 2006-01-02 or 2006-01-02T15:04", value)
+}
+
+// match tells if key passes every filter, glob is checked on the whole key and on its file name
+func (f keyFilter) match(key remoteObject) bool {
+	if f.Glob != "" {
+		whole, _ := path.Match(f.Glob, key.key)
+		base, _ := path.Match(f.Glob, path.Base(key.key))
+		if !whole && !base {
+			return false
+		}
+	}
+	if f.Regex != nil && !f.Regex.MatchString(key.key) {
+		return false
+	}
+	if !f.Since.IsZero() && key.updated.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !key.updated.Before(f.Until) {
+		return false
+	}
+	return true
+}
+
+func (f keyFilter) apply(keys []remoteObject) []remoteObject {
+	var kept []remoteObject
+	for _, key := range keys {
+		if f.match(key) {
+			kept = append(kept, key)
+		}
+	}
+	return kept
+}
+
+// fuzzyMatch tells if characters of filter appear in value in the same order, ignoring case and spaces
+func fuzzyMatch(filter string, value string) bool {
+	value = strings.ToLower(value)
+	for _, r := range strings.ToLower(filter) {
+		if unicode.IsSpace(r) {
+			continue
+		}
+		i := strings.IndexRune(value, r)
+		if i < 0 {
+			return false
+		}
+		value = value[i+len(string(r)):]
+	}
+	return true
+}
@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_KeyFilter(t *testing.T) {
+	now := time.Date(2026, time.October, 19, 12, 0, 0, 0, time.Local)
+	keys := []remoteObject{
+		{key: "db/hourly/app-2026-10-19-11.dump", updated: now.Add(-time.Hour)},
+		{key: "db/hourly/app-2026-10-01-08.dump", updated: time.Date(2026, time.October, 1, 8, 0, 0, 0, time.Local)},
+		{key: "db/hourly/app-2026-10-01-08.sql.gz", updated: time.Date(2026, time.October, 1, 8, 0, 0, 0, time.Local)},
+		{key: "db/hourly/app-2026-09-30-23.dump", updated: time.Date(2026, time.September, 30, 23, 0, 0, 0, time.Local)},
+	}
+	cases := []struct {
+		glob, regex, since, at string
+		want                   int
+	}{
+		{"", "", "", "", 4},
+		{"*.dump", "", "", "", 3},
+		{"", `\.sql\.gz$`, "", "", 1},
+		{"", "", "36h", "", 1},
+		{"", "", "2026-10-01", "", 3},
+		{"", "", "", "2026-10-01", 2},
+		{"*.dump", "", "", "2026-10-01", 1},
+	}
+	for _, c := range cases {
+		f, err := newKeyFilter(c.glob, c.regex, c.since, c.at, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := len(f.apply(keys)); got != c.want {
+			t.Errorf("%+v : got %d keys, want %d", c, got, c.want)
+		}
+	}
+
+	for _, invalid := range [][4]string{{"[", "", "", ""}, {"", "(", "", ""}, {"", "", "yesterday", ""}, {"", "", "", "01/10/2026"}} {
+		if _, err := newKeyFilter(invalid[0], invalid[1], invalid[2], invalid[3], now); err == nil {
+			t.Errorf("%v should be rejected", invalid)
+		}
+	}
+}
+
+func Test_FuzzyMatch(t *testing.T) {
+	value := "db/hourly/app-2026-10-01-08.dump  (1.0 KB, 2 weeks ago)"
+	for _, filter := range []string{"", "app1001", "HOURLY dump", "2026 10 01"} {
+		if !fuzzyMatch(filter, value) {
+			t.Errorf("%q should match", filter)
+		}
+	}
+	for _, filter := range []string{"dumpapp", "daily"} {
+		if fuzzyMatch(filter, value) {
+			t.Errorf("%q should not match", filter)
+		}
+	}
+}
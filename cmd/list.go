@@ -26,7 +26,6 @@ import (
 	"github.com/xeonx/timeago"
 	"log"
 	"net/url"
-	"os"
 	"sort"
 	"strings"
 	"time"
@@ -59,7 +58,7 @@ var listCmd = &cobra.Command{
 		}
 
 		if len(list) == 0 {
-			fmt.Println(chalk.Yellow.Color("No snapshots, run 'cappa snapshot'"))
+			fmt.Fprintln(cmd.OutOrStdout(), chalk.Yellow.Color("No snapshots, run 'cappa snapshot'"))
 			return
 		}
 
@@ -67,7 +66,7 @@ var listCmd = &cobra.Command{
 			return list[j].CreatedAt.Before(list[i].CreatedAt)
 		})
 
-		table := tablewriter.NewWriter(os.Stdout)
+		table := tablewriter.NewWriter(cmd.OutOrStdout())
 		table.SetHeader([]string{"Name", "Created"})
 		table.SetBorder(false)
 
@@ -106,7 +105,7 @@ func getProjectName() string {
 	project := strings.Split(u.Path, "/")[1]
 	log.Printf("projectName sets to : %s\n", project)
 	if project == "" {
-		fmt.Println("Error trying to get project name from config (did you set project value?)")
+		fmt.Fprintln(textOutput(), "Error trying to get project name from config (did you set project value?)")
 	}
 	return project
 }
@@ -0,0 +1,119 @@
+package cmd
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Hidden so restore never lists it
+const manifestName = ".manifest.json"
+
+// manifestEntry records where a local dump comes from and how it was used
+type manifestEntry struct {
+	// Url of the storage
+	Storage      string     `json:"storage,omitempty"`
+	Key          string     `json:"key,omitempty"`
+	VersionId    string     `json:"version_id,omitempty"`
+	ETag         string     `json:"etag,omitempty"`
+	Size         int64      `json:"size"`
+	DownloadedAt time.Time  `json:"downloaded_at"`
+	RestoredAt   *time.Time `json:"restored_at,omitempty"`
+}
+
+// origin tells where the dump was downloaded from
+func (e manifestEntry) origin() string {
+	if e.Storage == "" {
+		return ""
+	}
+	if e.VersionId != "" {
+		return e.Storage + "/" + e.Key + "?versionId=" + e.VersionId
+	}
+	return e.Storage + "/" + e.Key
+}
+
+// dumpManifest is kept in the dumps directory, keyed by file name
+type dumpManifest struct {
+	Dumps map[string]*manifestEntry `json:"dumps"`
+
+	path string
+}
+
+// loadManifest reads the manifest of dir, a missing one is empty
+func loadManifest(dir string) (*dumpManifest, error) {
+	m := &dumpManifest{Dumps: map[string]*manifestEntry{}, path: filepath.Join(dir, manifestName)}
+	content, err := ioutil.ReadFile(m.path)
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return m, err
+	}
+	if err := json.Unmarshal(content, m); err != nil {
+		return m, err
+	}
+	if m.Dumps == nil {
+		m.Dumps = map[string]*manifestEntry{}
+	}
+	return m, nil
+}
+
+func (m *dumpManifest) save() error {
+	return saveJson(m.path, m)
+}
+
+// saveJson writes v to a temporary file first then renames it, an interrupted save keeps the previous file
+func saveJson(path string, v interface{}) error {
+	content, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, content, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// updateManifest loads the manifest of dir, applies change and saves it
+func updateManifest(dir string, change func(m *dumpManifest)) error {
+	m, err := loadManifest(dir)
+	if err != nil {
+		return err
+	}
+	change(m)
+	return m.save()
+}
+
+// forgetMissing drops entries of dumps removed by hand
+func (m *dumpManifest) forgetMissing(dir string) {
+	for name := range m.Dumps {
+		if _, err := os.Stat(filepath.Join(dir, name)); os.IsNotExist(err) {
+			delete(m.Dumps, name)
+		}
+	}
+}
+
+// recordDownload remembers the object a dump was downloaded from
+func recordDownload(dir string, filename string, entry manifestEntry) error {
+	return updateManifest(dir, func(m *dumpManifest) {
+		m.Dumps[filename] = &entry
+	})
+}
+
+// recordRestore remembers when a dump was last restored, dumps not downloaded by grab get an entry too
+func recordRestore(dir string, filename string, at time.Time) error {
+	return updateManifest(dir, func(m *dumpManifest) {
+		entry, ok := m.Dumps[filename]
+		if !ok {
+			entry = &manifestEntry{}
+			if info, err := os.Stat(filepath.Join(dir, filename)); err == nil {
+				entry.Size, entry.DownloadedAt = info.Size(), info.ModTime()
+			}
+			m.Dumps[filename] = entry
+		}
+		entry.RestoredAt = &at
+	})
+}
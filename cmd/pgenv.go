@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// libpqEnvVars maps connection url parameters to the environment variables read by libpq.
+// pgx understands the same url parameters, so pgx and client tools connect the same way.
+var libpqEnvVars = map[string]string{
+	"host":                 "PGHOST",
+	"port":                 "PGPORT",
+	"sslmode":              "PGSSLMODE",
+	"sslcert":              "PGSSLCERT",
+	"sslkey":               "PGSSLKEY",
+	"sslrootcert":          "PGSSLROOTCERT",
+	"connect_timeout":      "PGCONNECT_TIMEOUT",
+	"application_name":     "PGAPPNAME",
+	"target_session_attrs": "PGTARGETSESSIONATTRS",
+	"options":              "PGOPTIONS",
+}
+
+// libpqEnv returns the environment needed by client tools (pg_restore, psql, ...) to reach database
+// with the same credentials and options as connUrl. Password never appears in command line arguments.
+func libpqEnv(connUrl string, database string) ([]string, error) {
+	u, err := url.Parse(connUrl)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse conn url : %s", redact(err.Error()))
+	}
+
+	env := map[string]string{}
+	if host := u.Hostname(); host != "" {
+		env["PGHOST"] = host
+	}
+	if port := u.Port(); port != "" {
+		env["PGPORT"] = port
+	}
+	if u.User != nil {
+		if username := u.User.Username(); username != "" {
+			env["PGUSER"] = username
+		}
+		if password, ok := u.User.Password(); ok {
+			env["PGPASSWORD"] = password
+		}
+	}
+	for param, values := range u.Query() {
+		if name, ok := libpqEnvVars[param]; ok && len(values) > 0 {
+			env[name] = values[len(values)-1]
+		}
+	}
+	if database == "" {
+		database = strings.TrimPrefix(u.Path, "/")
+	}
+	env["PGDATABASE"] = database
+
+	// Start from current environment, stale PG* values are overridden by ours (last one wins)
+	result := os.Environ()
+	for name, value := range env {
+		result = append(result, fmt.Sprintf("%s=%s", name, value))
+	}
+	return result, nil
+}
+
+// pgCommand prepares a client tool invocation connected to database
+func pgCommand(tool string, connUrl string, database string, args ...string) (*exec.Cmd, error) {
+	env, err := libpqEnv(connUrl, database)
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command(tool, args...)
+	cmd.Env = env
+	return cmd, nil
+}
@@ -0,0 +1,182 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/spf13/viper"
+)
+
+// pgBinGlobs are common install locations of PostgreSQL client tools, searched after configured dirs and PATH
+var pgBinGlobs = []string{
+	"/usr/lib/postgresql/*/bin",
+	"/usr/pgsql-*/bin",
+	"/usr/local/pgsql/bin",
+	"/opt/homebrew/opt/postgresql@*/bin",
+	"/opt/homebrew/opt/libpq/bin",
+	"/usr/local/opt/postgresql@*/bin",
+	"/usr/local/opt/libpq/bin",
+	"/Applications/Postgres.app/Contents/Versions/*/bin",
+	`C:\Program Files\PostgreSQL\*\bin`,
+}
+
+var toolVersionPattern = regexp.MustCompile(`\(PostgreSQL\) (\d+)(?:\.(\d+))?(?:\.(\d+))?`)
+
+// pgTool is a client binary and its version in server_version_num format (e.g. 160002 for 16.2)
+type pgTool struct {
+	Path       string
+	VersionNum int
+}
+
+// major returns version_num / 100 so 9.6 (906) and 16 (1600) compare correctly
+func (t pgTool) major() int {
+	return t.VersionNum / 100
+}
+
+func (t pgTool) String() string {
+	return fmt.Sprintf("%s (%s)", t.Path, formatMajor(t.major()))
+}
+
+// formatMajor prints a major version as PostgreSQL does : 9.6, 12, 16
+func formatMajor(major int) string {
+	if major >= 1000 {
+		return strconv.Itoa(major / 100)
+	}
+	return fmt.Sprintf("%d.%d", major/100, major%100)
+}
+
+// parseToolVersion turns `pg_restore (PostgreSQL) 16.2 (Ubuntu ...)` into 160002
+func parseToolVersion(output string) (int, error) {
+	m := toolVersionPattern.FindStringSubmatch(output)
+	if m == nil {
+		return 0, fmt.Errorf("unrecognized version string %q", strings.TrimSpace(output))
+	}
+	parts := []int{0, 0, 0}
+	for i := range parts {
+		if m[i+1] != "" {
+			parts[i], _ = strconv.Atoi(m[i+1])
+		}
+	}
+	if parts[0] >= 10 {
+		// Since 10, versions have two parts : major.minor
+		return parts[0]*10000 + parts[1], nil
+	}
+	return parts[0]*10000 + parts[1]*100 + parts[2], nil
+}
+
+// serverMajor returns server_version_num / 100 of the server conn is connected to
+func serverMajor(conn *pgx.Conn) (int, error) {
+	var versionNum string
+	err := conn.QueryRow(context.Background(), "SHOW server_version_num;").Scan(&versionNum)
+	if err != nil {
+		return 0, err
+	}
+	num, err := strconv.Atoi(versionNum)
+	if err != nil {
+		return 0, err
+	}
+	return num / 100, nil
+}
+
+// candidateToolPaths lists every executable named tool in configured dirs, PATH and common locations
+func candidateToolPaths(tool string) []string {
+	var found []string
+	for _, dir := range viper.GetStringSlice("pg_bin_dirs") {
+		if path, err := exec.LookPath(filepath.Join(dir, tool)); err == nil {
+			found = append(found, path)
+		}
+	}
+	if path, err := exec.LookPath(tool); err == nil {
+		found = append(found, path)
+	}
+	for _, pattern := range pgBinGlobs {
+		dirs, _ := filepath.Glob(pattern)
+		sort.Sort(sort.Reverse(sort.StringSlice(dirs)))
+		for _, dir := range dirs {
+			if path, err := exec.LookPath(filepath.Join(dir, tool)); err == nil {
+				found = append(found, path)
+			}
+		}
+	}
+
+	// The same binary is often reachable from PATH and from its install dir
+	var paths []string
+	seen := map[string]bool{}
+	for _, path := range found {
+		resolved, err := filepath.EvalSymlinks(path)
+		if err != nil {
+			resolved = path
+		}
+		if !seen[resolved] {
+			seen[resolved] = true
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// availableTools runs `tool --version` on every candidate
+func availableTools(tool string) []pgTool {
+	var tools []pgTool
+	for _, path := range candidateToolPaths(tool) {
+		out, err := exec.Command(path, "--version").Output()
+		if err != nil {
+			log.Printf("Ignoring %s : %s", path, err)
+			continue
+		}
+		num, err := parseToolVersion(string(out))
+		if err != nil {
+			log.Printf("Ignoring %s : %s", path, err)
+			continue
+		}
+		tools = append(tools, pgTool{Path: path, VersionNum: num})
+	}
+	return tools
+}
+
+// findPgTool picks a client tool whose major version is at least minMajor.
+// The one matching preferMajor (usually server version) wins, then the newest one.
+// Only pg_restore and psql are looked for, cappa never runs pg_dump.
+func findPgTool(name string, minMajor int, preferMajor int) (pgTool, error) {
+	tool, err := pickPgTool(name, availableTools(name), minMajor, preferMajor)
+	if err != nil {
+		return tool, err
+	}
+	log.Printf("Using %s", tool)
+	return tool, nil
+}
+
+func pickPgTool(name string, tools []pgTool, minMajor int, preferMajor int) (pgTool, error) {
+	var compatible []pgTool
+	for _, t := range tools {
+		if t.major() >= minMajor {
+			compatible = append(compatible, t)
+		}
+	}
+	if len(compatible) == 0 {
+		if len(tools) == 0 {
+			return pgTool{}, fmt.Errorf("%s not found in PATH nor in common install locations, set 'pg_bin_dirs' in %s", name, configFileName)
+		}
+		var found []string
+		for _, t := range tools {
+			found = append(found, t.String())
+		}
+		return pgTool{}, fmt.Errorf("no %s compatible found, PostgreSQL %s or newer is required but only found :\n  %s\nInstall a newer client or set 'pg_bin_dirs' in %s",
+			name, formatMajor(minMajor), strings.Join(found, "\n  "), configFileName)
+	}
+	for _, t := range compatible {
+		if t.major() == preferMajor {
+			return t, nil
+		}
+	}
+	sort.SliceStable(compatible, func(i, j int) bool { return compatible[i].VersionNum > compatible[j].VersionNum })
+	return compatible[0], nil
+}
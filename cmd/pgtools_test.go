@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func Test_ParseToolVersion(t *testing.T) {
+	cases := map[string]int{
+		"pg_restore (PostgreSQL) 16.2 (Ubuntu 16.2-1.pgdg22.04+1)\n": 160002,
+		"pg_restore (PostgreSQL) 12.4\n":                             120004,
+		"pg_dump (PostgreSQL) 9.6.24\n":                              90624,
+	}
+	for output, expected := range cases {
+		num, err := parseToolVersion(output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if num != expected {
+			t.Fatalf("expected %d for %q, got %d", expected, output, num)
+		}
+	}
+}
+
+func Test_PickPgToolMatchesArchiveAndServer(t *testing.T) {
+	tools := []pgTool{
+		{Path: "/usr/bin/pg_restore", VersionNum: 120004},
+		{Path: "/usr/lib/postgresql/17/bin/pg_restore", VersionNum: 170001},
+		{Path: "/usr/lib/postgresql/16/bin/pg_restore", VersionNum: 160002},
+	}
+
+	// Dump made by pg_dump 16 restored on a 16 server
+	tool, err := pickPgTool("pg_restore", tools, 1600, 1600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tool.major() != 1600 {
+		t.Fatalf("expected pg_restore 16, got %s", tool)
+	}
+
+	// Server 12 but dump needs 16 : newest compatible
+	tool, err = pickPgTool("pg_restore", tools, 1600, 1200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tool.major() != 1700 {
+		t.Fatalf("expected pg_restore 17, got %s", tool)
+	}
+
+	_, err = pickPgTool("pg_restore", tools[:1], 1600, 1600)
+	if err == nil || !strings.Contains(err.Error(), "PostgreSQL 16 or newer is required") {
+		t.Fatalf("expected a clear error, got %v", err)
+	}
+}
+
+func Test_ReadArchiveVersion(t *testing.T) {
+	v, err := readArchiveVersion(bytes.NewReader([]byte("PGDMP\x01\x0f\x00\x04\x08\x01")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.String() != "1.15-0" || v.minToolMajor() != 1600 {
+		t.Fatalf("unexpected archive version %s", v)
+	}
+	if _, err := readArchiveVersion(strings.NewReader("-- PostgreSQL database dump")); err == nil {
+		t.Fatalf("plain sql must not be read as an archive")
+	}
+}
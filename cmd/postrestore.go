@@ -0,0 +1,195 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/spf13/viper"
+)
+
+// assertion is a check run on a restored database, configured in [[post_restore.assert]] sections
+type assertion struct {
+	Name    string `mapstructure:"name"`
+	Table   string `mapstructure:"table"`
+	MinRows int64  `mapstructure:"min_rows"`
+	// Query returning a single boolean, true when the check passes
+	Sql string `mapstructure:"sql"`
+}
+
+func (a assertion) String() string {
+	switch {
+	case a.Name != "":
+		return a.Name
+	case a.Sql != "":
+		return a.Sql
+	case a.MinRows > 0:
+		return fmt.Sprintf("%s has at least %d rows", a.Table, a.MinRows)
+	default:
+		return fmt.Sprintf("table %s exists", a.Table)
+	}
+}
+
+// check runs the assertion, the returned message explains a failure
+func (a assertion) check(conn *pgx.Conn) (ok bool, message string, err error) {
+	if a.Sql != "" {
+		if err := conn.QueryRow(context.Background(), a.Sql).Scan(&ok); err != nil {
+			return false, "", err
+		}
+		if !ok {
+			return false, "query returned false", nil
+		}
+		return true, "", nil
+	}
+	if a.Table == "" {
+		return false, "", fmt.Errorf("assertion needs a table or a sql query")
+	}
+
+	// to_regclass quotes the table name for us and is null when the table is missing
+	var table *string
+	if err := conn.QueryRow(context.Background(), "SELECT to_regclass($1)::text", a.Table).Scan(&table); err != nil {
+		return false, "", err
+	}
+	if table == nil {
+		return false, fmt.Sprintf("table %s does not exist", a.Table), nil
+	}
+	if a.MinRows <= 0 {
+		return true, "", nil
+	}
+	var count int64
+	if err := conn.QueryRow(context.Background(), fmt.Sprintf("SELECT count(*) FROM %s", *table)).Scan(&count); err != nil {
+		return false, "", err
+	}
+	if count < a.MinRows {
+		return false, fmt.Sprintf("%s has %d rows, expected at least %d", a.Table, count, a.MinRows), nil
+	}
+	return true, fmt.Sprintf("%d rows", count), nil
+}
+
+// postRestoreConfig lists steps run after restore and back, from the [post_restore] section of config
+type postRestoreConfig struct {
+	// analyze, vacuum_analyze or empty
+	Analyze         string
+	ResyncSequences bool
+	Assertions      []assertion
+}
+
+func postRestoreConfigFromViper() (postRestoreConfig, error) {
+	c := postRestoreConfig{
+		Analyze:         viper.GetString("post_restore.analyze"),
+		ResyncSequences: viper.GetBool("post_restore.resync_sequences"),
+	}
+	if err := viper.UnmarshalKey("post_restore.assert", &c.Assertions); err != nil {
+		return c, fmt.Errorf("invalid post_restore.assert : %s", err)
+	}
+	switch c.Analyze {
+	case "", "none", "analyze", "vacuum_analyze":
+	default:
+		return c, fmt.Errorf("post_restore.analyze must be analyze, vacuum_analyze or none, not %q", c.Analyze)
+	}
+	return c, nil
+}
+
+func (c postRestoreConfig) empty() bool {
+	return (c.Analyze == "" || c.Analyze == "none") && !c.ResyncSequences && len(c.Assertions) == 0
+}
+
+// stepResult is the outcome of a post-restore step
+type stepResult struct {
+	Name     string  `json:"name"`
+	Success  bool    `json:"success"`
+	Message  string  `json:"message,omitempty"`
+	Duration float64 `json:"duration_seconds"`
+}
+
+// Sequences owned by a column (serial or identity) with the column they feed
+const ownedSequencesQuery = `SELECT s.oid::regclass::text, t.oid::regclass::text, a.attname
+FROM pg_class s
+JOIN pg_depend d ON d.objid = s.oid AND d.classid = 'pg_class'::regclass AND d.refclassid = 'pg_class'::regclass AND d.deptype IN ('a', 'i')
+JOIN pg_class t ON t.oid = d.refobjid
+JOIN pg_attribute a ON a.attrelid = t.oid AND a.attnum = d.refobjsubid
+WHERE s.relkind = 'S'`
+
+// resyncSequences sets every owned sequence after the greatest value of its column
+func resyncSequences(conn *pgx.Conn) (int, error) {
+	type owned struct{ sequence, table, column string }
+	var sequences []owned
+	rows, err := conn.Query(context.Background(), ownedSequencesQuery)
+	if err != nil {
+		return 0, err
+	}
+	for rows.Next() {
+		var o owned
+		if err := rows.Scan(&o.sequence, &o.table, &o.column); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		sequences = append(sequences, o)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, o := range sequences {
+		// Empty tables restart at the sequence minimum
+		query := fmt.Sprintf(`SELECT setval($1::regclass, coalesce(max(%[1]s), (SELECT seqmin FROM pg_sequence WHERE seqrelid = $1::regclass)), max(%[1]s) IS NOT NULL) FROM %[2]s`,
+			pgx.Identifier{o.column}.Sanitize(), o.table)
+		log.Print(query)
+		if _, err := conn.Exec(context.Background(), query, o.sequence); err != nil {
+			return 0, fmt.Errorf("%s : %s", o.sequence, err)
+		}
+	}
+	return len(sequences), nil
+}
+
+// runPostRestore runs configured steps on database, every step runs even when a previous one failed
+func runPostRestore(connUrl string, database string) ([]stepResult, error) {
+	c, err := postRestoreConfigFromViper()
+	if err != nil {
+		return nil, err
+	}
+	if c.empty() {
+		return nil, nil
+	}
+
+	conn := createConnection(siblingDbUrl(connUrl, database))
+	defer conn.Close(context.Background())
+
+	var results []stepResult
+	run := func(name string, step func() (bool, string, error)) {
+		start := time.Now()
+		ok, message, err := step()
+		if err != nil {
+			ok, message = false, err.Error()
+		}
+		results = append(results, stepResult{Name: name, Success: ok, Message: redact(message), Duration: time.Since(start).Seconds()})
+	}
+
+	switch c.Analyze {
+	case "analyze":
+		run("analyze", func() (bool, string, error) {
+			_, err := conn.Exec(context.Background(), "ANALYZE")
+			return err == nil, "", err
+		})
+	case "vacuum_analyze":
+		run("vacuum analyze", func() (bool, string, error) {
+			_, err := conn.Exec(context.Background(), "VACUUM ANALYZE")
+			return err == nil, "", err
+		})
+	}
+	if c.ResyncSequences {
+		run("resync sequences", func() (bool, string, error) {
+			n, err := resyncSequences(conn)
+			return err == nil, fmt.Sprintf("%d sequences", n), err
+		})
+	}
+	for _, a := range c.Assertions {
+		run(a.String(), func() (bool, string, error) {
+			return a.check(conn)
+		})
+	}
+	return results, nil
+}
@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func Test_AssertionDescription(t *testing.T) {
+	cases := map[string]assertion{
+		"users has at least 100 rows": {Table: "users", MinRows: 100},
+		"table audit.events exists":   {Table: "audit.events"},
+		"admin is there":              {Name: "admin is there", Sql: "select exists(select 1 from users)"},
+		"select true":                 {Sql: "select true"},
+	}
+	for want, a := range cases {
+		if got := a.String(); got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	}
+}
+
+func Test_PostRestoreConfig(t *testing.T) {
+	viper.Set("post_restore.analyze", "vacuum_analyze")
+	viper.Set("post_restore.assert", []map[string]interface{}{
+		{"table": "users", "min_rows": 100},
+		{"name": "admin is there", "sql": "select true"},
+	})
+	defer func() {
+		viper.Set("post_restore.analyze", nil)
+		viper.Set("post_restore.assert", nil)
+	}()
+
+	c, err := postRestoreConfigFromViper()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.empty() || c.Analyze != "vacuum_analyze" || len(c.Assertions) != 2 {
+		t.Fatalf("unexpected config %+v", c)
+	}
+	if c.Assertions[0].Table != "users" || c.Assertions[0].MinRows != 100 || c.Assertions[1].Sql != "select true" {
+		t.Errorf("unexpected assertions %+v", c.Assertions)
+	}
+
+	viper.Set("post_restore.analyze", "full")
+	if _, err := postRestoreConfigFromViper(); err == nil {
+		t.Error("expected an error for an unknown analyze value")
+	}
+}
+
+func Test_FailedStepFailsReport(t *testing.T) {
+	var out bytes.Buffer
+	r := &restoreReport{Command: "restore", out: &out}
+	r.Steps = []stepResult{
+		{Name: "analyze", Success: true},
+		{Name: "users has at least 100 rows", Message: "users has 3 rows, expected at least 100"},
+	}
+	r.finish(nil)
+	if r.Success {
+		t.Error("a failed assertion should fail the report")
+	}
+
+	outputFormat = "json"
+	defer func() { outputFormat = "text" }()
+	r.print()
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &decoded); err != nil {
+		t.Fatalf("invalid json %q : %s", out.String(), err)
+	}
+	if decoded["success"] != false || fmt.Sprint(decoded["error"]) != "1 post-restore step(s) failed" {
+		t.Errorf("unexpected report %v", decoded)
+	}
+}
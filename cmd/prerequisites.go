@@ -0,0 +1,213 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/AlecAivazis/survey/v2"
+	"github.com/AlecAivazis/survey/v2/terminal"
+	"github.com/jackc/pgx/v4"
+	"github.com/spf13/viper"
+	"github.com/ttacon/chalk"
+)
+
+// Extensions every new database already has
+var builtinExtensions = map[string]bool{
+	"plpgsql": true,
+}
+
+// CREATE EXTENSION IF NOT EXISTS postgis WITH SCHEMA "geo";
+var extensionSchemaPattern = regexp.MustCompile(`WITH SCHEMA ("(?:[^"]|"")+"|[^\s;]+)`)
+
+// prerequisites are extensions a dump needs on the server.
+// Owners are not: pg_restore runs with --no-owner and --no-acl, roles of the dump are never used.
+type prerequisites struct {
+	Extensions []string
+	// Schema each extension was dumped in
+	Schemas map[string]string
+	// Found when checking the server
+	AvailableExtensions   []string
+	UnavailableExtensions []string
+}
+
+// dumpPrerequisites lists extensions found in the table of contents of an archive
+func dumpPrerequisites(toc *archiveToc) *prerequisites {
+	extensions := map[string]bool{}
+	schemas := map[string]string{}
+	for _, e := range toc.Entries {
+		if e.Desc == "EXTENSION" && !builtinExtensions[e.Tag] {
+			extensions[e.Tag] = true
+			schemas[e.Tag] = extensionSchema(e.Defn)
+		}
+	}
+	return &prerequisites{Extensions: sortedKeys(extensions), Schemas: schemas}
+}
+
+// extensionSchema reads the schema of a CREATE EXTENSION statement, empty when it has none
+func extensionSchema(defn string) string {
+	m := extensionSchemaPattern.FindStringSubmatch(defn)
+	if m == nil {
+		return ""
+	}
+	if strings.HasPrefix(m[1], `"`) {
+		return strings.Replace(m[1][1:len(m[1])-1], `""`, `"`, -1)
+	}
+	return m[1]
+}
+
+// stringSet turns keys of m in a set
+func stringSet(m map[string]string) map[string]bool {
+	set := map[string]bool{}
+	for k := range m {
+		set[k] = true
+	}
+	return set
+}
+
+func sortedKeys(m map[string]bool) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// check finds which extensions this server can install
+func (p *prerequisites) check(conn *pgx.Conn) error {
+	available, err := queryNames(conn, "SELECT name FROM pg_available_extensions WHERE name = ANY($1)", p.Extensions)
+	if err != nil {
+		return err
+	}
+
+	p.AvailableExtensions, p.UnavailableExtensions = nil, nil
+	for _, extension := range p.Extensions {
+		if available[extension] {
+			p.AvailableExtensions = append(p.AvailableExtensions, extension)
+		} else {
+			p.UnavailableExtensions = append(p.UnavailableExtensions, extension)
+		}
+	}
+	return nil
+}
+
+// queryNames returns names found by a query filtering on a list of names
+func queryNames(conn *pgx.Conn, query string, names []string) (map[string]bool, error) {
+	found := map[string]bool{}
+	rows, err := conn.Query(context.Background(), query, names)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		found[name] = true
+	}
+	return found, rows.Err()
+}
+
+// resolve reports what is missing and returns extensions to install in the restored database with their schema.
+// Without createMissing, user is asked unless canAsk is false.
+func (p *prerequisites) resolve(createMissing bool, canAsk bool, out terminal.FileWriter) map[string]string {
+	if len(p.UnavailableExtensions) > 0 {
+		fmt.Fprintln(out, chalk.Yellow.Color(fmt.Sprintf("Extensions not installable on this server, objects using them will fail : %s", strings.Join(p.UnavailableExtensions, ", "))))
+	}
+
+	if len(p.AvailableExtensions) > 0 {
+		message := fmt.Sprintf("Install extensions %s before restoring ?", strings.Join(p.AvailableExtensions, ", "))
+		if confirmPrerequisite(out, message, createMissing, canAsk) {
+			extensions := map[string]string{}
+			for _, extension := range p.AvailableExtensions {
+				extensions[extension] = p.Schemas[extension]
+			}
+			return extensions
+		}
+	}
+	return nil
+}
+
+func confirmPrerequisite(out terminal.FileWriter, message string, createMissing bool, canAsk bool) bool {
+	if createMissing {
+		return true
+	}
+	if !canAsk || nonInteractive {
+		return false
+	}
+	confirmed := false
+	err := survey.AskOne(&survey.Confirm{Message: message, Default: true}, &confirmed, askOn(out))
+	if err == terminal.InterruptErr {
+		fmt.Fprintln(out, "User terminated prompt")
+		os.Exit(0)
+	} else if err != nil {
+		log.Printf("Could not ask for confirmation : %s", err)
+		return false
+	}
+	return confirmed
+}
+
+// installExtensions creates extensions in database as admin_database_url, a superuser, or with connUrl otherwise.
+// Extensions are created in the schema they were dumped in. When that schema does not exist yet,
+// it is created by the dump : the extension is left to pg_restore.
+// It returns extensions installed, pg_restore must skip them.
+func installExtensions(connUrl string, database string, extensions map[string]string, out terminal.FileWriter) []string {
+	if len(extensions) == 0 {
+		return nil
+	}
+	if admin := viper.GetString("admin_database_url"); admin != "" {
+		connUrl = admin
+	}
+	conn, err := pgx.Connect(context.Background(), siblingDbUrl(connUrl, database))
+	if err != nil {
+		fmt.Fprintln(out, chalk.Yellow.Color(fmt.Sprintf("Could not connect to install extensions : %s", redact(err.Error()))))
+		return nil
+	}
+	defer conn.Close(context.Background())
+	var schemas []string
+	for _, schema := range extensions {
+		if schema != "" {
+			schemas = append(schemas, schema)
+		}
+	}
+	existing, err := queryNames(conn, "SELECT nspname FROM pg_namespace WHERE nspname = ANY($1)", schemas)
+	if err != nil {
+		fmt.Fprintln(out, chalk.Yellow.Color(fmt.Sprintf("Could not read schemas to install extensions : %s", redact(err.Error()))))
+		return nil
+	}
+	var installed []string
+	for _, extension := range sortedKeys(stringSet(extensions)) {
+		query := fmt.Sprintf("CREATE EXTENSION IF NOT EXISTS %s", pgx.Identifier{extension}.Sanitize())
+		if schema := extensions[extension]; schema != "" {
+			if !existing[schema] {
+				log.Printf("Extension %s is restored by pg_restore, its schema %s is created by the dump", extension, schema)
+				continue
+			}
+			query += " WITH SCHEMA " + pgx.Identifier{schema}.Sanitize()
+		}
+		log.Print(query)
+		if _, err := conn.Exec(context.Background(), query); err != nil {
+			fmt.Fprintln(out, chalk.Yellow.Color(fmt.Sprintf("Could not install extension %s : %s", extension, redact(err.Error()))))
+			continue
+		}
+		installed = append(installed, extension)
+	}
+	return installed
+}
+
+// preparePrerequisites checks what an archive needs and returns extensions to install with their schema
+func preparePrerequisites(conn *pgx.Conn, toc *archiveToc, input *dumpInput, out terminal.FileWriter) map[string]string {
+	p := dumpPrerequisites(toc)
+	if err := p.check(conn); err != nil {
+		log.Printf("Could not check prerequisites of dump : %s", err)
+		return nil
+	}
+	// Prompts would read the dump when it comes from stdin
+	return p.resolve(viper.GetBool("restore.create_missing"), !input.stdin, out)
+}
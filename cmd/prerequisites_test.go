@@ -0,0 +1,34 @@
+package cmd
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func Test_DumpPrerequisites(t *testing.T) {
+	toc := &archiveToc{Entries: []archiveEntry{
+		{Desc: "EXTENSION", Tag: "plpgsql"},
+		{Desc: "EXTENSION", Tag: "postgis", Defn: `CREATE EXTENSION IF NOT EXISTS postgis WITH SCHEMA "geo ""data""";`},
+		{Desc: "EXTENSION", Tag: "pg_trgm", Defn: "CREATE EXTENSION IF NOT EXISTS pg_trgm WITH SCHEMA extensions;"},
+		{Desc: "SCHEMA", Tag: "sales", Owner: "sales_owner"},
+		{Desc: "TABLE", Namespace: "sales", Tag: "orders", Owner: "sales_owner"},
+		{Desc: "TABLE", Namespace: "public", Tag: "users", Owner: "app"},
+	}}
+	p := dumpPrerequisites(toc)
+	if want := []string{"pg_trgm", "postgis"}; !reflect.DeepEqual(p.Extensions, want) {
+		t.Errorf("got extensions %v, want %v", p.Extensions, want)
+	}
+	if want := map[string]string{"pg_trgm": "extensions", "postgis": `geo "data"`}; !reflect.DeepEqual(p.Schemas, want) {
+		t.Errorf("got schemas %v, want %v", p.Schemas, want)
+	}
+}
+
+func Test_ConfirmPrerequisiteWithoutPrompt(t *testing.T) {
+	if !confirmPrerequisite(os.Stdout, "Create ?", true, false) {
+		t.Error("--create-missing should confirm")
+	}
+	if confirmPrerequisite(os.Stdout, "Create ?", false, false) {
+		t.Error("nothing should be created when user can not be asked")
+	}
+}
@@ -0,0 +1,127 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"gopkg.in/cheggaaa/pb.v1"
+)
+
+// pg_restore --verbose prints one of these lines for each restored item
+var restoredItemPattern = regexp.MustCompile(`^pg_restore: (?:creating|executing|processing data for table) (.*)$`)
+
+// restoreProgress turns pg_restore verbose output into a progress bar showing restored items and dump bytes.
+// Bytes are counted as the restore tool reads a streamed dump, or from data sizes of the table of contents
+// when the tool reads a local file by itself. The bar follows bytes when their total is known, items otherwise.
+type restoreProgress struct {
+	bar   *pb.ProgressBar
+	total int
+	done  int
+	// Dump bytes, size is 0 when unknown
+	size int64
+	read int64
+	// Data sizes by "schema.table", nil when bytes are counted from the stream
+	dataSizes map[string]int64
+}
+
+// newRestoreProgress starts a bar on out for total items. size is the dump size in bytes or 0 when unknown,
+// dataSizes gives bytes restored with the data of each table when the dump is not streamed.
+func newRestoreProgress(out io.Writer, total int, size int64, dataSizes map[string]int64) *restoreProgress {
+	p := &restoreProgress{total: total, size: size, dataSizes: dataSizes}
+	if p.byBytes() {
+		p.bar = pb.New64(size).SetUnits(pb.U_BYTES)
+	} else {
+		p.bar = pb.New(total)
+	}
+	p.bar.Output = out
+	p.bar.ShowTimeLeft = true
+	p.bar.ShowSpeed = p.byBytes()
+	p.bar.Prefix(p.prefix())
+	p.bar.Start()
+	return p
+}
+
+func (p *restoreProgress) byBytes() bool {
+	return p.size > 0
+}
+
+// prefix shows items, psql has none, and bytes read when the bar does not
+func (p *restoreProgress) prefix() string {
+	s := ""
+	if p.total > 0 {
+		s = fmt.Sprintf("%d/%d items ", p.done, p.total)
+	} else if p.done > 0 {
+		s = fmt.Sprintf("%d items ", p.done)
+	}
+	if !p.byBytes() && p.read > 0 {
+		s += formatSize(p.read) + " "
+	}
+	return s
+}
+
+// addBytes counts dump bytes read from the stream by the restore tool
+func (p *restoreProgress) addBytes(n int64) {
+	if p.dataSizes != nil {
+		return
+	}
+	p.add(n)
+}
+
+func (p *restoreProgress) add(n int64) {
+	p.read += n
+	if p.byBytes() {
+		p.bar.Add64(n)
+	} else {
+		p.bar.Prefix(p.prefix())
+	}
+}
+
+// line consumes a pg_restore output line and tells if it was a progress line
+func (p *restoreProgress) line(l string) bool {
+	m := restoredItemPattern.FindStringSubmatch(l)
+	if m == nil {
+		return false
+	}
+	name := strings.Trim(m[1], `"`)
+	p.done++
+	if !p.byBytes() && p.done <= p.total {
+		p.bar.Increment()
+	}
+	if n := p.dataSizes[name]; n > 0 && strings.HasPrefix(l, "pg_restore: processing data for table ") {
+		p.add(n)
+	}
+	p.bar.Prefix(p.prefix())
+	p.bar.Postfix(" " + name)
+	return true
+}
+
+// tocDataSizes maps "schema.table" to the size of its data in the archive, as pg_restore names tables
+func tocDataSizes(toc *archiveToc) (sizes map[string]int64, total int64) {
+	sizes = map[string]int64{}
+	if toc == nil {
+		return sizes, 0
+	}
+	for _, e := range toc.Entries {
+		if e.Desc == "TABLE DATA" && e.Size > 0 {
+			sizes[e.Namespace+"."+e.Tag] += e.Size
+			total += e.Size
+		}
+	}
+	return sizes, total
+}
+
+func (p *restoreProgress) finish() {
+	p.bar.Postfix("")
+	p.bar.Set64(p.bar.Total)
+	p.bar.Finish()
+}
+
+// isRestoreNoise tells if a pg_restore output line is only informational
+func isRestoreNoise(l string) bool {
+	if !strings.HasPrefix(l, "pg_restore: ") {
+		return false
+	}
+	return !strings.Contains(l, "error") && !strings.Contains(l, "warning")
+}
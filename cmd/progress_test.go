@@ -0,0 +1,41 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func Test_RestoreProgressCountsItemsAndBytes(t *testing.T) {
+	toc := &archiveToc{Entries: []archiveEntry{
+		{Desc: "TABLE", Namespace: "public", Tag: "users"},
+		{Desc: "TABLE DATA", Namespace: "public", Tag: "users", Size: 600},
+		{Desc: "TABLE DATA", Namespace: "sales", Tag: "orders", Size: 400},
+	}}
+	sizes, total := tocDataSizes(toc)
+	if total != 1000 {
+		t.Fatalf("unexpected data size %d", total)
+	}
+
+	// Local file : bytes come from data sizes
+	p := newRestoreProgress(ioutil.Discard, 3, total, sizes)
+	p.addBytes(50)
+	p.line(`pg_restore: creating TABLE "public.users"`)
+	p.line(`pg_restore: processing data for table "public.users"`)
+	if p.done != 2 || p.read != 600 || p.bar.Get() != 600 {
+		t.Errorf("expected 2 items and 600 bytes, got %d items, %d bytes", p.done, p.read)
+	}
+	if !strings.HasPrefix(p.prefix(), "2/3 items") {
+		t.Errorf("items should be in prefix : %q", p.prefix())
+	}
+	p.finish()
+
+	// Stream of unknown size : the bar counts items, bytes read are in the prefix
+	p = newRestoreProgress(ioutil.Discard, 3, 0, nil)
+	p.addBytes(2048)
+	p.line(`pg_restore: creating TABLE "public.users"`)
+	if p.bar.Get() != 1 || p.prefix() != "1/3 items 2.00 KiB " {
+		t.Errorf("unexpected bar %d, prefix %q", p.bar.Get(), p.prefix())
+	}
+	p.finish()
+}
@@ -0,0 +1,442 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// pullStepNames are the steps of a pull, in the order they run
+var pullStepNames = []string{"grab", "restore", "sanitize", "snapshot"}
+
+// nonInteractive makes prompts fail instead of waiting for an answer, pull runs unattended
+var nonInteractive bool
+
+var pullFrom string
+var pullResume bool
+
+// Hidden so restore never lists them
+const (
+	pullStateName = ".pull.json"
+	pullLogName   = ".pull.log"
+)
+
+// pullRecipe is the [pull] section of the config file
+type pullRecipe struct {
+	// Named source, or url, dumps are grabbed from. Bucket settings are used when empty.
+	Source string
+	// Filters of grab, those of the source otherwise
+	Match string
+	Regex string
+	Since string
+	// Sql files run after restore, .cappa/execute.sql when it exists otherwise
+	Sanitize []string
+	// Name of the snapshot, {date} is replaced by the day of the pull
+	Snapshot string
+	// An existing snapshot of the same name is replaced, the pull fails otherwise
+	ReplaceSnapshot bool
+	// Steps to run, all of them by default
+	Steps []string
+	// Where dumps are downloaded and the state of the pull kept
+	Dir string
+
+	source sourceConfig
+}
+
+func pullRecipeFromConfig() (pullRecipe, error) {
+	recipe := pullRecipe{
+		Source:   viper.GetString("pull.source"),
+		Match:    viper.GetString("pull.match"),
+		Regex:    viper.GetString("pull.regex"),
+		Since:    viper.GetString("pull.since"),
+		Sanitize: viper.GetStringSlice("pull.sanitize"),
+		Snapshot: viper.GetString("pull.snapshot"),
+		Steps:    viper.GetStringSlice("pull.steps"),
+		Dir:      viper.GetString("dest"),
+
+		ReplaceSnapshot: viper.GetBool("pull.replace_snapshot"),
+	}
+	if recipe.Snapshot == "" {
+		recipe.Snapshot = "pull-{date}"
+	}
+	steps, err := orderedPullSteps(recipe.Steps)
+	if err != nil {
+		return recipe, err
+	}
+	recipe.Steps = steps
+
+	if recipe.Source != "" {
+		recipe.source, err = loadSource(recipe.Source)
+	} else if containsString(recipe.Steps, "grab") {
+		recipe.source, err = bucketSource()
+	}
+	if err != nil {
+		return recipe, err
+	}
+	if recipe.source.Dest != "" {
+		recipe.Dir = recipe.source.Dest
+	}
+	if recipe.Dir == "" {
+		recipe.Dir = ".cappa"
+	}
+	return recipe, nil
+}
+
+// orderedPullSteps checks step names and puts them in the order they run
+func orderedPullSteps(names []string) ([]string, error) {
+	if len(names) == 0 {
+		return pullStepNames, nil
+	}
+	for _, name := range names {
+		if !containsString(pullStepNames, name) {
+			return nil, fmt.Errorf("unknown pull step %q, steps are %s", name, strings.Join(pullStepNames, ", "))
+		}
+	}
+	var steps []string
+	for _, name := range pullStepNames {
+		if containsString(names, name) {
+			steps = append(steps, name)
+		}
+	}
+	return steps, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// snapshotName replaces {date} by the day the pull started, a resumed pull keeps the same name
+func (r pullRecipe) snapshotName(started time.Time) string {
+	return strings.Replace(r.Snapshot, "{date}", started.Format("2006-01-02"), -1)
+}
+
+// pullState is what a pull did so far, saved after each step so a failed pull can resume
+type pullState struct {
+	Started time.Time `json:"started"`
+	// Path of the grabbed dump
+	Dump  string       `json:"dump,omitempty"`
+	Steps []stepResult `json:"steps"`
+
+	path string
+}
+
+// loadPullState reads the state of the last pull in dir, an empty one when there was none
+func loadPullState(dir string) (*pullState, error) {
+	s := &pullState{Steps: []stepResult{}, path: filepath.Join(dir, pullStateName)}
+	content, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return s, err
+	}
+	return s, json.Unmarshal(content, s)
+}
+
+func (s *pullState) save() error {
+	return saveJson(s.path, s)
+}
+
+func (s *pullState) succeeded(step string) bool {
+	for _, r := range s.Steps {
+		if r.Name == step {
+			return r.Success
+		}
+	}
+	return false
+}
+
+// record replaces the result of a previous run of the step
+func (s *pullState) record(result stepResult) {
+	for i, r := range s.Steps {
+		if r.Name == result.Name {
+			s.Steps[i] = result
+			return
+		}
+	}
+	s.Steps = append(s.Steps, result)
+}
+
+// forget drops results of steps which run again
+func (s *pullState) forget(steps []string) {
+	var kept []stepResult
+	for _, r := range s.Steps {
+		if !containsString(steps, r.Name) {
+			kept = append(kept, r)
+		}
+	}
+	s.Steps = kept
+}
+
+// pullActions run each step and tell what they did
+var pullActions = map[string]func(recipe pullRecipe, state *pullState) (string, error){
+	"grab":     pullGrab,
+	"restore":  pullRestore,
+	"sanitize": pullSanitize,
+	"snapshot": pullSnapshot,
+}
+
+// firstPullStep returns where a pull starts : --from, the first step which did not succeed
+// last time with --resume, or the beginning
+func firstPullStep(steps []string, state *pullState, from string, resume bool) (int, error) {
+	if from != "" {
+		for i, name := range steps {
+			if name == from {
+				return i, nil
+			}
+		}
+		return 0, fmt.Errorf("--from must be one of %s", strings.Join(steps, ", "))
+	}
+	if resume {
+		for i, name := range steps {
+			if !state.succeeded(name) {
+				return i, nil
+			}
+		}
+		log.Printf("Last pull completed, starting a new one")
+	}
+	return 0, nil
+}
+
+// runPull runs steps of recipe from a step, recording each of them in the state and log of the pull
+func runPull(recipe pullRecipe, from string, resume bool, out io.Writer) error {
+	if err := os.MkdirAll(recipe.Dir, 0700); err != nil {
+		return err
+	}
+	state, err := loadPullState(recipe.Dir)
+	if err != nil {
+		return fmt.Errorf("could not read state of last pull : %s", err)
+	}
+	start, err := firstPullStep(recipe.Steps, state, from, resume)
+	if err != nil {
+		return err
+	}
+	// Only a resumed pull keeps its date, and the name of its snapshot
+	if resume && start > 0 {
+		fmt.Fprintf(out, "Resuming pull of %s from %s\n", state.Started.Format("2006-01-02 15:04"), recipe.Steps[start])
+	} else {
+		state.Started = time.Now()
+	}
+	if start == 0 {
+		state = &pullState{Started: state.Started, Steps: []stepResult{}, path: state.path}
+	} else {
+		state.forget(recipe.Steps[start:])
+	}
+
+	logFile, err := os.OpenFile(filepath.Join(recipe.Dir, pullLogName), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+	stepLog := log.New(newRedactWriter(logFile), "", log.LstdFlags)
+
+	for _, name := range recipe.Steps[start:] {
+		stepLog.Printf("%s started", name)
+		began := time.Now()
+		message, err := pullActions[name](recipe, state)
+		result := stepResult{Name: name, Success: err == nil, Message: message, Duration: time.Since(began).Seconds()}
+		if err != nil {
+			result.Message = redact(err.Error())
+			stepLog.Printf("%s failed after %.1fs : %s", name, result.Duration, result.Message)
+		} else {
+			stepLog.Printf("%s done in %.1fs : %s", name, result.Duration, result.Message)
+		}
+		state.record(result)
+		if err := state.save(); err != nil {
+			log.Printf("Could not save state of pull : %s", err)
+		}
+		printStep(out, result)
+		if err != nil {
+			return fmt.Errorf("pull stopped at %s, run 'cappa pull --resume' once fixed", name)
+		}
+	}
+	return nil
+}
+
+// pullGrab downloads the newest dump of the source, unless it already was
+func pullGrab(recipe pullRecipe, state *pullState) (string, error) {
+	store, prefix, err := openStorage(recipe.source)
+	if err != nil {
+		return "", err
+	}
+	defer store.Close()
+
+	match, regex := recipe.Match, recipe.Regex
+	if match == "" && regex == "" {
+		match, regex = recipe.source.Match, recipe.source.Regex
+	}
+	filter, err := newKeyFilter(match, regex, recipe.Since, "", time.Now())
+	if err != nil {
+		return "", err
+	}
+	objects, err := store.List(prefix)
+	if err != nil {
+		return "", err
+	}
+	objects = filter.apply(objects)
+	if len(objects) == 0 {
+		return "", fmt.Errorf("no dump found in %s matching filters", store)
+	}
+
+	latest := objects[0]
+	name := latest.localName()
+	state.Dump = filepath.Join(recipe.Dir, name)
+	if alreadyDownloaded(recipe.Dir, name, store, latest) {
+		return fmt.Sprintf("%s already downloaded", name), nil
+	}
+	if err := Download(store, latest, name, recipe.Dir, textOutput()); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s from %s", name, store), nil
+}
+
+// alreadyDownloaded tells if the manifest of dir has this version of the object as name
+func alreadyDownloaded(dir string, name string, store storage, obj remoteObject) bool {
+	if _, err := os.Stat(filepath.Join(dir, name)); err != nil || obj.etag == "" {
+		return false
+	}
+	manifest, err := loadManifest(dir)
+	if err != nil {
+		return false
+	}
+	entry := manifest.Dumps[name]
+	return entry != nil && entry.Storage == store.String() && entry.Key == obj.key && entry.ETag == obj.etag
+}
+
+// pullRestore restores the grabbed dump, or the newest one of dir when grab is not a step
+func pullRestore(recipe pullRecipe, state *pullState) (string, error) {
+	if state.Dump == "" {
+		dumps, _, err := localDumps(recipe.Dir)
+		if err != nil {
+			return "", err
+		}
+		if len(dumps) == 0 {
+			return "", fmt.Errorf("no dump found in %s", recipe.Dir)
+		}
+		state.Dump = filepath.Join(recipe.Dir, dumps[0].Name)
+	}
+	input, err := openLocalSource(state.Dump)
+	if err != nil {
+		return "", err
+	}
+	defer input.Close()
+	report, err := newRestoreReport("restore")
+	if err != nil {
+		return "", err
+	}
+	err = restoreInput(input, report)
+	report.finish(err)
+	report.printSteps()
+	if !report.Success {
+		return "", errors.New(report.Error)
+	}
+	if err := recordRestore(filepath.Dir(state.Dump), filepath.Base(state.Dump), time.Now()); err != nil {
+		log.Printf("Could not record restore in manifest : %s", err)
+	}
+	return fmt.Sprintf("%s into %s", input.Name, report.Database), nil
+}
+
+// pullSanitize runs sanitizing sql, a failed statement stops the pull before the snapshot is taken
+func pullSanitize(recipe pullRecipe, state *pullState) (string, error) {
+	files := recipe.Sanitize
+	if len(files) == 0 {
+		defaultFile := path.Join(".cappa", "execute.sql")
+		if _, err := os.Stat(defaultFile); os.IsNotExist(err) {
+			return "nothing to run, no pull.sanitize nor .cappa/execute.sql", nil
+		}
+		files = []string{defaultFile}
+	}
+	total := 0
+	for _, file := range files {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return "", err
+		}
+		executed, failed, err := executeStatements(trackedDbUrl, string(content), textOutput())
+		if err != nil {
+			return "", err
+		}
+		if failed > 0 {
+			return "", fmt.Errorf("%d of %d statements of %s failed", failed, executed, file)
+		}
+		total += executed
+	}
+	return fmt.Sprintf("%d statements of %s", total, strings.Join(files, ", ")), nil
+}
+
+// pullSnapshot creates the snapshot, a previous one of the same name is removed once the new one exists
+func pullSnapshot(recipe pullRecipe, state *pullState) (string, error) {
+	name := recipe.snapshotName(state.Started)
+	previous, err := findSnapshots(name)
+	if err != nil {
+		return "", err
+	}
+	if len(previous) > 0 && !recipe.ReplaceSnapshot {
+		return "", fmt.Errorf("snapshot %s already exists, delete it or set replace_snapshot in [pull] (--replace-snapshot)", name)
+	}
+	if err := createSnapshot(name, textOutput()); err != nil {
+		return "", err
+	}
+	for _, snap := range previous {
+		if err := removeSnapshot(snap); err != nil {
+			return "", fmt.Errorf("snapshot %s created but the previous one could not be removed : %s", name, err)
+		}
+	}
+	if len(previous) > 0 {
+		return name + ", replacing the previous one", nil
+	}
+	return name, nil
+}
+
+var pullCmd = &cobra.Command{
+	Use:   "pull",
+	Short: "Grab, restore, sanitize and snapshot the newest dump without any prompt",
+	Long: `Run the [pull] recipe of the config file : grab the newest dump of a source, restore it,
+run sanitizing sql then create a snapshot. Nothing is asked, prompts fail and confirmations take
+their default answer. A failed pull resumes where it stopped with --resume.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		nonInteractive = true
+		// Restore reports of pull are text, steps are printed as they finish
+		outputFormat = "text"
+		// Extensions the dump needs are installed, like the default answer of restore
+		if !viper.IsSet("restore.create_missing") {
+			viper.Set("restore.create_missing", true)
+		}
+		recipe, err := pullRecipeFromConfig()
+		if err != nil {
+			return err
+		}
+		return runPull(recipe, pullFrom, pullResume, cmd.OutOrStdout())
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(pullCmd)
+
+	pullCmd.Flags().String("source", "", "Named source of the config file, or url, to grab from")
+	pullCmd.Flags().String("snapshot", "", "Name of the snapshot created at the end, {date} is replaced by the day (default pull-{date})")
+	pullCmd.Flags().Bool("replace-snapshot", false, "Replace a snapshot of the same name instead of failing")
+	pullCmd.Flags().StringVar(&pullFrom, "from", "", "Start from this step : grab, restore, sanitize or snapshot")
+	pullCmd.Flags().BoolVar(&pullResume, "resume", false, "Start from the step which failed last time")
+
+	bindFlag("pull.source", pullCmd.Flags().Lookup("source"))
+	bindFlag("pull.snapshot", pullCmd.Flags().Lookup("snapshot"))
+	bindFlag("pull.replace_snapshot", pullCmd.Flags().Lookup("replace-snapshot"))
+}
@@ -0,0 +1,184 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// fakePullActions records steps run, failing those of failing
+func fakePullActions(ran *[]string, failing map[string]bool) func() {
+	saved := pullActions
+	pullActions = map[string]func(recipe pullRecipe, state *pullState) (string, error){}
+	for _, name := range pullStepNames {
+		name := name
+		pullActions[name] = func(recipe pullRecipe, state *pullState) (string, error) {
+			*ran = append(*ran, name)
+			if failing[name] {
+				return "", errors.New(name + " broke")
+			}
+			return name + " ok", nil
+		}
+	}
+	return func() { pullActions = saved }
+}
+
+func Test_PullResumesFromFailedStep(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	var ran []string
+	failing := map[string]bool{"sanitize": true}
+	defer fakePullActions(&ran, failing)()
+	recipe := pullRecipe{Steps: pullStepNames, Dir: dir}
+
+	var out bytes.Buffer
+	err := runPull(recipe, "", false, &out)
+	if err == nil || !strings.Contains(err.Error(), "--resume") {
+		t.Fatalf("failed step should stop the pull, got %v", err)
+	}
+	if strings.Join(ran, ",") != "grab,restore,sanitize" {
+		t.Errorf("unexpected steps %v", ran)
+	}
+	logged, _ := ioutil.ReadFile(filepath.Join(dir, pullLogName))
+	if !strings.Contains(string(logged), "sanitize failed") || !strings.Contains(string(logged), "sanitize broke") {
+		t.Errorf("failure not logged :\n%s", logged)
+	}
+
+	ran = nil
+	failing["sanitize"] = false
+	if err := runPull(recipe, "", true, &out); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(ran, ",") != "sanitize,snapshot" {
+		t.Errorf("resume should start at the failed step, ran %v", ran)
+	}
+	state, _ := loadPullState(dir)
+	if len(state.Steps) != 4 || !state.succeeded("grab") || !state.succeeded("snapshot") {
+		t.Errorf("unexpected state %+v", state.Steps)
+	}
+
+	// Nothing left to resume, a new pull starts
+	ran = nil
+	if err := runPull(recipe, "", true, &out); err != nil || len(ran) != 4 {
+		t.Errorf("completed pull should start over, ran %v (%v)", ran, err)
+	}
+	ran = nil
+	if err := runPull(recipe, "snapshot", false, &out); err != nil || strings.Join(ran, ",") != "snapshot" {
+		t.Errorf("--from should start at the step, ran %v (%v)", ran, err)
+	}
+	if err := runPull(recipe, "sanitise", false, &out); err == nil {
+		t.Error("unknown --from step should be rejected")
+	}
+}
+
+func Test_PullFromStepStartsANewPull(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	var ran []string
+	defer fakePullActions(&ran, nil)()
+	var snapshotDay time.Time
+	pullActions["snapshot"] = func(recipe pullRecipe, state *pullState) (string, error) {
+		snapshotDay = state.Started
+		return recipe.snapshotName(state.Started), nil
+	}
+	recipe := pullRecipe{Steps: pullStepNames, Dir: dir, Snapshot: "pull-{date}"}
+
+	var out bytes.Buffer
+	if err := runPull(recipe, "restore", false, &out); err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(snapshotDay) > time.Minute {
+		t.Errorf("--from without a previous pull should be dated now, got %s", snapshotDay)
+	}
+
+	// An old completed pull does not give its date to the next one
+	state, _ := loadPullState(dir)
+	state.Started = time.Now().AddDate(0, 0, -7)
+	state.save()
+	if err := runPull(recipe, "snapshot", false, &out); err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(snapshotDay) > time.Minute {
+		t.Errorf("--from after an old pull should be dated now, got %s", snapshotDay)
+	}
+}
+
+func Test_PullGrabSkipsDownloadedDump(t *testing.T) {
+	backups, cleanup := tempDir(t)
+	defer cleanup()
+	dir, cleanupDir := tempDir(t)
+	defer cleanupDir()
+	store := &localStorage{root: backups}
+	store.Put("prod/monday.dump", bytes.NewReader(sampleCustomArchive(14)))
+	store.Put("prod/notes.txt", bytes.NewReader([]byte("not a dump")))
+
+	recipe := pullRecipe{Match: "*.dump", Dir: dir, source: sourceConfig{Url: backups, Prefix: "prod/"}}
+	state := &pullState{}
+	message, err := pullGrab(recipe, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Dump != filepath.Join(dir, "monday.dump") || strings.Contains(message, "already") {
+		t.Errorf("unexpected grab %q of %s", message, state.Dump)
+	}
+	if _, err := os.Stat(state.Dump); err != nil {
+		t.Fatal(err)
+	}
+
+	if message, err := pullGrab(recipe, state); err != nil || !strings.Contains(message, "already downloaded") {
+		t.Errorf("same dump should not be downloaded again, got %q (%v)", message, err)
+	}
+
+	recipe.Match = "*.sql"
+	if _, err := pullGrab(recipe, state); err == nil {
+		t.Error("no matching dump should fail the step")
+	}
+}
+
+func Test_PullRecipeFromConfig(t *testing.T) {
+	viper.Set("sources.nas.url", "/mnt/backups")
+	viper.Set("sources.nas.dest", "/tmp/nas-dumps")
+	viper.Set("pull.source", "nas")
+	viper.Set("pull.steps", []string{"snapshot", "grab"})
+	viper.Set("pull.snapshot", "prod-{date}")
+	viper.Set("pull.replace_snapshot", true)
+	defer func() {
+		viper.Set("sources", nil)
+		viper.Set("pull", nil)
+		viper.Set("pull.source", "")
+		viper.Set("pull.steps", nil)
+		viper.Set("pull.snapshot", "")
+		viper.Set("pull.replace_snapshot", nil)
+	}()
+
+	recipe, err := pullRecipeFromConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(recipe.Steps, ",") != "grab,snapshot" {
+		t.Errorf("steps should run in order, got %v", recipe.Steps)
+	}
+	if !recipe.ReplaceSnapshot {
+		t.Error("replace_snapshot not read")
+	}
+	if recipe.Dir != "/tmp/nas-dumps" || recipe.source.Url != "/mnt/backups" {
+		t.Errorf("source not used : %+v", recipe)
+	}
+	state := &pullState{}
+	state.Started, _ = parseDate("2026-10-19")
+	if name := recipe.snapshotName(state.Started); name != "prod-2026-10-19" {
+		t.Errorf("unexpected snapshot name %s", name)
+	}
+
+	viper.Set("pull.steps", []string{"grab", "vacuum"})
+	if _, err := pullRecipeFromConfig(); err == nil {
+		t.Error("unknown step should be rejected")
+	}
+}
@@ -0,0 +1,126 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/AlecAivazis/survey/v2"
+	"github.com/AlecAivazis/survey/v2/terminal"
+	"github.com/ttacon/chalk"
+)
+
+var outputFormat string
+
+// restoreReport is printed at the end of restore and back, as text or json
+type restoreReport struct {
+	Command  string       `json:"command"`
+	Source   string       `json:"source,omitempty"`
+	Database string       `json:"database,omitempty"`
+	Success  bool         `json:"success"`
+	Error    string       `json:"error,omitempty"`
+	Steps    []stepResult `json:"steps"`
+	Warnings []string     `json:"warnings,omitempty"`
+	Duration float64      `json:"duration_seconds"`
+
+	started time.Time
+	out     io.Writer
+	// text receives messages, progress and prompts of the command
+	text terminal.FileWriter
+}
+
+// newRestoreReport starts a report. In json mode everything else printed goes to stderr,
+// so stdout only holds the report.
+func newRestoreReport(command string) (*restoreReport, error) {
+	if outputFormat != "text" && outputFormat != "json" {
+		return nil, fmt.Errorf("output must be text or json, not %q", outputFormat)
+	}
+	return &restoreReport{Command: command, Steps: []stepResult{}, started: time.Now(), out: newRedactWriter(os.Stdout), text: textOutput()}, nil
+}
+
+// textOutput is where messages of a command go, stderr when stdout holds a json report
+func textOutput() terminal.FileWriter {
+	if outputFormat == "json" {
+		return newRedactFile(os.Stderr)
+	}
+	return newRedactFile(os.Stdout)
+}
+
+// askOn renders a prompt on out instead of stdout
+func askOn(out terminal.FileWriter) survey.AskOpt {
+	return survey.WithStdio(os.Stdin, out, os.Stderr)
+}
+
+// warn prints a warning now and keeps it for the final report
+func (r *restoreReport) warn(message string) {
+	fmt.Fprintln(r.text, chalk.Yellow.Color(message))
+	r.Warnings = append(r.Warnings, message)
+}
+
+// failedSteps counts post-restore steps which failed
+func (r *restoreReport) failedSteps() int {
+	failed := 0
+	for _, s := range r.Steps {
+		if !s.Success {
+			failed++
+		}
+	}
+	return failed
+}
+
+// finish records the outcome, a failed step fails the whole command
+func (r *restoreReport) finish(err error) {
+	r.Duration = time.Since(r.started).Seconds()
+	if err == nil && r.failedSteps() > 0 {
+		err = fmt.Errorf("%d post-restore step(s) failed", r.failedSteps())
+	}
+	r.Success = err == nil
+	if err != nil {
+		r.Error = redact(err.Error())
+	}
+}
+
+func (r *restoreReport) print() {
+	if outputFormat == "json" {
+		encoder := json.NewEncoder(r.out)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(r)
+		return
+	}
+	r.printSteps()
+	for _, w := range r.Warnings {
+		fmt.Fprintln(r.out, chalk.Yellow.Color("! "+w))
+	}
+	if r.Error != "" {
+		fmt.Fprintf(r.out, "Error while %s : %s\n", map[string]string{"restore": "restoring", "back": "reverting"}[r.Command], r.Error)
+	}
+}
+
+func (r *restoreReport) printSteps() {
+	for _, s := range r.Steps {
+		printStep(r.out, s)
+	}
+}
+
+// printStep shows a step as a line with its duration
+func printStep(out io.Writer, s stepResult) {
+	line := fmt.Sprintf("%s (%.1fs)", s.Name, s.Duration)
+	if s.Message != "" {
+		line += " : " + s.Message
+	}
+	if s.Success {
+		fmt.Fprintln(out, chalk.Green.Color("✔ "+line))
+	} else {
+		fmt.Fprintln(out, chalk.Red.Color("✘ "+line))
+	}
+}
+
+// postRestore runs post-restore steps on database and records them
+func (r *restoreReport) postRestore(connUrl string, database string) error {
+	r.Database = database
+	steps, err := runPostRestore(connUrl, database)
+	r.Steps = append(r.Steps, steps...)
+	return err
+}
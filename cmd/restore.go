@@ -20,38 +20,55 @@ import (
 	"context"
 	"fmt"
 	"github.com/AlecAivazis/survey/v2"
+	"github.com/AlecAivazis/survey/v2/terminal"
 	"github.com/jackc/pgx/v4"
+	"github.com/lithammer/shortuuid/v3"
 	"github.com/spf13/viper"
+	"github.com/ttacon/chalk"
+	"io"
 	"io/ioutil"
 	"log"
-	"net"
-	"net/url"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"runtime"
 
 	"strings"
+	"time"
 
 	_ "github.com/lib/pq"
 	"github.com/spf13/cobra"
 )
 
 var directory string
+var asSnapshot string
 
 // restoreCmd represents the restore command
 var restoreCmd = &cobra.Command{
-	Use:     "restore",
+	Use:     "restore [source]",
 	Aliases: []string{"r"},
 	Short:   "Restore from backup file",
+	Long: `Restore a dump picked in --dir, or from source which can be a local path, - for stdin,
+an http(s):// url, s3://bucket/key, gs://bucket/key or sftp://host/path. Remote sources are streamed, never written to disk.`,
+	Args: cobra.MaximumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		err := restoreFromDir(directory)
+		report, err := newRestoreReport("restore")
 		if err != nil {
-			fmt.Printf("Error while restoring from dir : %s", err)
+			log.Fatal(err)
+		}
+		if len(args) == 1 {
+			err = restoreFromSource(args[0], report)
+		} else {
+			err = restoreFromDir(directory, report)
+		}
+		report.finish(err)
+		report.print()
+		if !report.Success {
+			os.Exit(1)
 		}
 	},
 }
 
-func restoreFromDir(dir string) error {
+func restoreFromDir(dir string, report *restoreReport) error {
 
 	_, err := os.Stat(dir)
 	if err != nil {
@@ -59,26 +76,159 @@ func restoreFromDir(dir string) error {
 		return fmt.Errorf("Directory %s does not exists", dir)
 	}
 
-	defaultDbConn := createConnection(defaultDbUrl)
+	backupSelected, err := PickFileIn(dir, report.text)
+	if err != nil {
+		return err
+	}
+
+	input, err := openLocalSource(filepath.Join(dir, backupSelected))
+	if err != nil {
+		return err
+	}
+	defer input.Close()
+	if err := restoreInput(input, report); err != nil {
+		return err
+	}
+	if err := recordRestore(dir, backupSelected, time.Now()); err != nil {
+		log.Printf("Could not record restore in manifest : %s", err)
+	}
+	return nil
+}
+
+// restoreFromSource restores a dump given as a path, - for stdin, an http(s):// or s3:// url
+func restoreFromSource(source string, report *restoreReport) error {
+	input, err := openSource(source)
+	if err != nil {
+		return err
+	}
+	defer input.Close()
+	return restoreInput(input, report)
+}
+
+func restoreInput(input *dumpInput, report *restoreReport) error {
+	report.Source = input.Name
+	kind := input.Kind
+	if !kind.restorable() {
+		return fmt.Errorf("%s is not a dump cappa can restore (%s)", input.Name, kind)
+	}
+
+	defaultDbConn, err := connect(defaultDbUrl)
+	if err != nil {
+		return err
+	}
 	defer defaultDbConn.Close(context.Background())
 
-	backupSelected, err := PickFileIn(dir)
+	opts := restoreOptionsFromConfig()
+	opts.out = report.text
+	if err := opts.Filter.validate(); err != nil {
+		return err
+	}
+	if !opts.Filter.empty() && !kind.usesPgRestore() {
+		return fmt.Errorf("tables and schemas can not be selected in %s dumps", kind)
+	}
+	if asSnapshot != "" && opts.Filter.DataOnly {
+		return fmt.Errorf("--data-only needs an existing database, it can not be restored as a snapshot")
+	}
+
+	tool, err := restoreToolFor(defaultDbConn, input)
+	if err != nil {
+		return err
+	}
+
+	// Table of contents tells what the dump needs and how its database was created
+	var toc *archiveToc
+	if kind.usesPgRestore() {
+		toc, err = dumpArchiveToc(input)
+		if err != nil {
+			log.Printf("Could not read table of contents of dump : %s", err)
+		} else {
+			opts.toc = toc
+			opts.Extensions = preparePrerequisites(defaultDbConn, toc, input, report.text)
+		}
+	}
+	server, err := serverMajor(defaultDbConn)
 	if err != nil {
+		return fmt.Errorf("could not read server version : %s", err)
+	}
+	opts.Locale = restoreLocale(defaultDbConn, toc, server)
+	if err := opts.Locale.validate(); err != nil {
 		return err
 	}
+	log.Printf("Database will be created with %s", opts.Locale)
 
-	dumpPath := filepath.Join(dir, backupSelected)
+	fmt.Fprintf(report.text, "Start restore from dump file %v (%s)\nPlease wait...\n", input.Name, kind)
+	if !opts.Filter.empty() {
+		fmt.Fprintf(report.text, "Restoring %s\n", opts.Filter)
+	}
 
-	fmt.Printf("Start restore from dump file %v\nPlease wait...", dumpPath)
+	if asSnapshot != "" {
+		return restoreAsSnapshot(defaultDbConn, tool, input, opts, asSnapshot, report)
+	}
+
+	// Data only restore loads data into existing tables, database is kept
+	if !opts.Filter.DataOnly {
+		if DatabaseExists(defaultDbConn, getProjectName()) {
+			TerminateDatabaseConnections(defaultDbConn, getProjectName())
+			DropDatabase(defaultDbConn, getProjectName())
+		}
 
-	if DatabaseExists(defaultDbConn, getProjectName()) {
-		TerminateDatabaseConnections(defaultDbConn, getProjectName())
-		DropDatabase(defaultDbConn, getProjectName())
+		if err := createRestoreDatabase(defaultDbConn, getProjectName(), opts.Locale, report); err != nil {
+			return err
+		}
+	}
+	report.Database = getProjectName()
+	if err := restoreDatabase(tool, input, trackedDbUrl, getProjectName(), opts); err != nil {
+		return err
 	}
-	CreateDatabase(defaultDbConn, getProjectName())
-	restoreDatabase(dumpPath, trackedDbUrl)
+	return report.postRestore(trackedDbUrl, getProjectName())
+}
 
-	return nil
+// restoreAsSnapshot restores a dump into a new cappa_<hash> database registered as a snapshot,
+// tracked database is left untouched and 'cappa back' can switch to it later
+func restoreAsSnapshot(defaultDbConn *pgx.Conn, tool pgTool, input *dumpInput, opts restoreOptions, name string, report *restoreReport) error {
+	hash := strings.ToLower(shortuuid.New())
+	toDatabase := fmt.Sprintf("%s_%s", cliName, hash)
+
+	if err := createRestoreDatabase(defaultDbConn, toDatabase, opts.Locale, report); err != nil {
+		return err
+	}
+	report.Database = toDatabase
+	if err := restoreDatabase(tool, input, trackedDbUrl, toDatabase, opts); err != nil {
+		DropDatabase(defaultDbConn, toDatabase)
+		return err
+	}
+	// Snapshot is kept when a check fails, the report tells what is wrong with it
+	stepsErr := report.postRestore(trackedDbUrl, toDatabase)
+
+	trackerConn := createConnection(cliDbUrl)
+	defer trackerConn.Close(context.Background())
+	if err := registerSnapshot(trackerConn, hash, name); err != nil {
+		return fmt.Errorf("dump restored in %s but snapshot could not be registered : %s", toDatabase, err)
+	}
+	fmt.Fprintf(report.text, "Snapshot %s created from %s, run 'cappa back' to use it\n", name, input.Name)
+	return stepsErr
+}
+
+// restoreToolFor finds the client restoring this kind of dump : pg_restore able to read the archive
+// or psql for plain sql, matching server version when possible
+func restoreToolFor(conn *pgx.Conn, input *dumpInput) (pgTool, error) {
+	server, err := serverMajor(conn)
+	if err != nil {
+		return pgTool{}, fmt.Errorf("could not read server version : %s", err)
+	}
+	if !input.Kind.usesPgRestore() {
+		return findPgTool("psql", 0, server)
+	}
+	minMajor := 0
+	archive, ok, err := dumpArchiveVersion(input)
+	if err != nil {
+		return pgTool{}, err
+	}
+	if ok {
+		minMajor = archive.minToolMajor()
+		log.Printf("Dump archive version %s, server version %s", archive, formatMajor(server))
+	}
+	return findPgTool("pg_restore", minMajor, server)
 }
 
 func DatabaseExists(conn *pgx.Conn, database string) bool {
@@ -97,44 +247,73 @@ func DatabaseExists(conn *pgx.Conn, database string) bool {
 	return exists
 }
 
-func ensurepath(command string) string {
-	_, err := exec.LookPath(command)
-	if err != nil {
-		log.Fatalf("%v is not found", command)
-		panic(err)
-	}
-	return command
+// cappaFile tells if a file of a dumps directory is hidden (manifest, pull state), an unfinished download
+// or sql executed by 'cappa execute', none of them is a dump
+func cappaFile(name string) bool {
+	return strings.HasPrefix(name, ".") || strings.HasPrefix(name, "cappa-") || name == "execute.sql"
 }
 
-func PickFileIn(dir string) (string, error) {
-	var Selector []string
-
+// restorableDumps lists dumps of dir cappa can restore, newest name first
+func restorableDumps(dir string) (names []string, kinds []dumpKind, err error) {
 	completeList, err := ioutil.ReadDir(dir)
-	for i := len(completeList)/2 - 1; i >= 0; i-- {
-		opp := len(completeList) - 1 - i
-		completeList[i], completeList[opp] = completeList[opp], completeList[i]
+	if err != nil {
+		return nil, nil, err
 	}
+	for i := len(completeList) - 1; i >= 0; i-- {
+		name := completeList[i].Name()
+		if cappaFile(name) {
+			continue
+		}
+		// Encrypted dumps are listed without being decrypted, it could need a passphrase
+		if e := encryptionOf(filepath.Join(dir, name)); e != encryptionNone {
+			names = append(names, name)
+			kinds = append(kinds, dumpKind{Encryption: e})
+			continue
+		}
+		kind, err := detectDump(filepath.Join(dir, name))
+		if err != nil {
+			log.Printf("Ignoring %s : %s", name, err)
+			continue
+		}
+		if kind.restorable() {
+			names = append(names, name)
+			kinds = append(kinds, kind)
+		}
+	}
+	return names, kinds, nil
+}
+
+// PickFileIn asks which dump of dir to use, the prompt is rendered on out
+func PickFileIn(dir string, out terminal.FileWriter) (string, error) {
+	dumps, _, err := localDumps(dir)
 	if err != nil {
 		log.Fatal(err)
 	}
-	if len(completeList) == 0 {
-		return "", fmt.Errorf("Directory exists but is empty")
+	if len(dumps) == 0 {
+		return "", fmt.Errorf("No dump file found in %s", dir)
 	}
 
-	for _, localbackup := range completeList {
-		//fmt.Printf("%s | %d\n", backup.key, backup.size)
-		Selector = append(Selector, localbackup.Name())
+	// Newest download first
+	var Selector []string
+	for _, d := range dumps {
+		input := &dumpInput{Name: d.Name, Path: filepath.Join(dir, d.Name), Kind: d.Kind}
+		Selector = append(Selector, fmt.Sprintf("%s (%s)", d.Name, dumpSummary(input)))
 	}
 	backupSelected := ""
 	prompt := &survey.Select{
-		Message: fmt.Sprintf("Select local backup file in %s/:", viper.GetString("from-dir")),
+		Message: fmt.Sprintf("Select local backup file in %s/:", dir),
 		Options: Selector,
 	}
-	survey.AskOne(prompt, &backupSelected, nil)
+	survey.AskOne(prompt, &backupSelected, askOn(out))
 	if backupSelected == "" {
 		log.Fatal("No backup selected")
 	}
-	return backupSelected, nil
+	for i, option := range Selector {
+		if option == backupSelected {
+			return dumps[i].Name, nil
+		}
+	}
+	return "", fmt.Errorf("Unknown backup %s", backupSelected)
 }
 
 // TerminateDatabaseConnections force cuts all connections to database before drop or create operations
@@ -155,63 +334,314 @@ func TerminateDatabaseConnections(conn *pgx.Conn, database string) error {
 	return nil
 }
 
-func restoreDatabase(dumpPath string, connUrl string) {
+// restoreOptions tune how pg_restore runs
+type restoreOptions struct {
+	Jobs int
+	// Jobs were asked with --jobs or restore.jobs, not the number of CPUs
+	JobsSet      bool
+	Filter       tocFilter
+	IgnoreErrors []string
+	// Extensions installed before restoring, with the schema they were dumped in
+	Extensions map[string]string
+	// Settings of the created database
+	Locale databaseLocale
+
+	// Messages and progress of the restore
+	out terminal.FileWriter
+	// Table of contents of the archive, nil when unknown
+	toc *archiveToc
+}
 
-	// Check command is available
-	ensurepath("pg_restore")
-	c, err := url.Parse(connUrl)
-	if err != nil {
-		log.Printf("Unable to parse conn url : %s", err)
+func restoreOptionsFromConfig() restoreOptions {
+	jobs := viper.GetInt("restore.jobs")
+	if jobs <= 0 {
+		jobs = runtime.NumCPU()
+	}
+	return restoreOptions{
+		Jobs:         jobs,
+		JobsSet:      viper.IsSet("restore.jobs"),
+		IgnoreErrors: viper.GetStringSlice("restore.ignore_errors"),
+		Filter: tocFilter{
+			Tables:        viper.GetStringSlice("restore.tables"),
+			ExcludeTables: viper.GetStringSlice("restore.exclude_tables"),
+			Schemas:       viper.GetStringSlice("restore.schemas"),
+			SchemaOnly:    viper.GetBool("restore.schema_only"),
+			DataOnly:      viper.GetBool("restore.data_only"),
+		},
 	}
-	host, port, _ := net.SplitHostPort(c.Host)
+}
+
+// canRestoreInParallel tells if pg_restore --jobs can be used, it needs to seek in an archive file or directory
+func canRestoreInParallel(kind dumpKind) bool {
+	return (kind.Format == formatCustom || kind.Format == formatDirectory) && kind.plainFile()
+}
 
-	log.Printf("Start restore dump %v into database %v\nPlease wait ...\n", dumpPath, config.Database)
-	args := fmt.Sprintf("--host=%s --port=%s --username=%s --verbose --clean --disable-triggers --no-acl --no-owner -d %s %s", host, port, c.User.Username(), getProjectName(), dumpPath)
-	cmd := exec.Command("pg_restore", strings.Split(args, " ")...)
+// restoreDatabase runs the restore tool, it fails when the tool fails or reports errors not in the ignore-list
+func restoreDatabase(tool pgTool, input *dumpInput, connUrl string, database string, opts restoreOptions) error {
+	kind := input.Kind
+
+	log.Printf("Start restore %s dump %v into database %v\nPlease wait ...\n", kind, input.Name, database)
+	opts.Filter.InstalledExtensions = installExtensions(connUrl, database, opts.Extensions, opts.out)
+
+	var args []string
+	if kind.usesPgRestore() {
+		args = []string{"--verbose", "--disable-triggers", "--no-acl", "--no-owner", "--dbname", database}
+		if !opts.Filter.DataOnly {
+			args = append(args, "--clean", "--if-exists")
+		}
+	} else {
+		// Errors are reported and the script goes on, psql only fails on fatal errors
+		args = []string{"--no-psqlrc", "--quiet", "--set", "ON_ERROR_STOP=0", "--dbname", database}
+	}
+
+	parallel := opts.Jobs > 1 && input.local() && canRestoreInParallel(kind)
+	// Plain and tar dumps restore with a single job by default, only warn when jobs were asked
+	if opts.Jobs > 1 && !parallel && opts.JobsSet {
+		if input.local() {
+			fmt.Fprintln(opts.out, chalk.Yellow.Color(fmt.Sprintf("Parallel restore is not possible for %s dumps, restoring with a single job", kind)))
+		} else {
+			fmt.Fprintln(opts.out, chalk.Yellow.Color("Parallel restore is not possible for streamed dumps, restoring with a single job"))
+		}
+	}
+
+	// Selection is done by commenting out items in the table of contents given to pg_restore.
+	// It must be read before streaming, streams replay what was read to list it.
+	var items []tocItem
+	var err error
+	installed := len(opts.Filter.InstalledExtensions) > 0
+	if kind.usesPgRestore() && (!isVerbose() || !opts.Filter.empty() || installed) {
+		items, err = listArchive(tool, input)
+		if err != nil && !opts.Filter.empty() {
+			return fmt.Errorf("could not read table of contents : %s", err)
+		} else if err != nil {
+			log.Printf("Could not read table of contents, progress will be approximate and installed extensions restored again : %s", err)
+		}
+	}
+	if !opts.Filter.empty() || (installed && items != nil) {
+		listPath, err := tocListFile(items, opts.Filter)
+		if listPath != "" {
+			defer os.Remove(listPath)
+		}
+		if err != nil {
+			return fmt.Errorf("could not write table of contents : %s", err)
+		}
+		args = append([]string{fmt.Sprintf("--use-list=%s", listPath)}, args...)
+		items = filterToc(items, opts.Filter)
+	}
+
+	var stream io.ReadCloser
+	inputArgs, streamed := toolInputArgs(input, parallel, opts.Jobs)
+	args = append(args, inputArgs...)
+	if streamed {
+		stream, err = input.stream()
+		if err != nil {
+			return fmt.Errorf("could not read dump : %s", err)
+		}
+		defer stream.Close()
+	}
+
+	cmd, err := pgCommand(tool.Path, connUrl, database, args...)
+	if err != nil {
+		return fmt.Errorf("could not prepare command : %s", err)
+	}
+	log.Printf("Running %s", redact(strings.Join(cmd.Args, " ")))
+
+	// psql reading a file tells nothing to follow, its messages are printed as they come
+	var progress *restoreProgress
+	if !isVerbose() && (kind.usesPgRestore() || stream != nil) {
+		// Bytes are counted as the stream is read, or from data sizes when the tool reads the file
+		var size int64
+		var dataSizes map[string]int64
+		if stream != nil {
+			size = input.Size
+		} else {
+			dataSizes, size = tocDataSizes(opts.toc)
+		}
+		progress = newRestoreProgress(opts.out, restorableCount(items), size, dataSizes)
+	}
+	if stream != nil {
+		if progress != nil {
+			input.raw.attach(progress)
+		}
+		cmd.Stdin = stream
+	}
+	if isVerbose() {
+		cmd.Stdout = opts.out
+	}
 
 	stderr, _ := cmd.StderrPipe()
 
 	err = cmd.Start()
 	if err != nil {
-		log.Fatalf("Could not start command : %s", err)
+		return fmt.Errorf("could not start command : %s", err)
 	}
 
-	scanner := bufio.NewScanner(stderr)
-	scanner.Split(bufio.ScanLines)
-	for scanner.Scan() {
-		m := scanner.Text()
-		fmt.Println(m)
+	// Raw output is only shown with --verbose, errors are printed once progress is done.
+	// Ignored errors and the command which caused them are only shown with --verbose.
+	var messages []string
+	errs := newRestoreErrors(opts.IgnoreErrors)
+	skipCommand := false
+	tocEntryLine := ""
+	scanErr := scanToolLines(stderr, func(m string) {
+		e, isError := errs.line(m)
+		if progress == nil {
+			fmt.Fprintln(opts.out, redact(m))
+			return
+		}
+		// pg_restore tells which toc entry failed on the line before the error
+		if tocEntryLine != "" {
+			if !isError || !e.Ignored {
+				messages = append(messages, tocEntryLine)
+			}
+			tocEntryLine = ""
+		}
+		if isError && e.Ignored {
+			skipCommand = true
+			return
+		}
+		if skipCommand && strings.HasPrefix(m, "Command was:") {
+			skipCommand = false
+			return
+		}
+		skipCommand = false
+		if tocEntryContextPattern.MatchString(m) {
+			tocEntryLine = m
+			return
+		}
+		if !progress.line(m) && !isRestoreNoise(m) {
+			messages = append(messages, m)
+		}
+	})
+	if tocEntryLine != "" {
+		messages = append(messages, tocEntryLine)
 	}
 
-	cmd.Wait()
-	if err != nil {
-		log.Fatalf("Could not wait for command : %s", err)
+	waitErr := cmd.Wait()
+	if progress != nil {
+		progress.finish()
+	}
+	for _, m := range messages {
+		fmt.Fprintln(opts.out, redact(m))
+	}
+	if summary := errs.summary(); summary != "" {
+		fmt.Fprint(opts.out, redact(summary))
 	}
 
+	toolName := "psql"
+	if kind.usesPgRestore() {
+		toolName = "pg_restore"
+	}
+	if scanErr != nil {
+		// Errors after the unreadable line were not counted
+		return fmt.Errorf("could not read messages of %s : %s", toolName, scanErr)
+	}
+	if n := errs.unexpected(); n > 0 {
+		return fmt.Errorf("%d unexpected error(s) during restore, add them to restore.ignore_errors if they are expected", n)
+	}
+	return errs.exitError(toolName, waitErr)
 }
 
-func DropDatabase(conn *pgx.Conn, database string) {
-	query := fmt.Sprintf("DROP DATABASE %s;", database)
-	log.Print(query)
+// maxToolLine bounds a message line of pg_restore or psql, errors can quote a whole COPY line or function body
+var maxToolLine = 64 << 20
 
-	_, err := conn.Exec(context.Background(), query)
+// scanToolLines calls each for every line of r. r is always read to its end, a tool blocks
+// when nobody reads its full stderr pipe.
+func scanToolLines(r io.Reader, each func(line string)) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxToolLine)
+	for scanner.Scan() {
+		each(scanner.Text())
+	}
+	err := scanner.Err()
+	io.Copy(ioutil.Discard, r)
+	return err
+}
+
+// toolInputArgs gives local files tools can read as paths, pg_restore needs to seek in archives to follow --use-list,
+// progress then counts restored items. Other dumps are streamed (decrypted, decompressed) and progress counts bytes.
+func toolInputArgs(input *dumpInput, parallel bool, jobs int) (args []string, streamed bool) {
+	kind := input.Kind
+	switch {
+	case parallel:
+		return []string{fmt.Sprintf("--jobs=%d", jobs), input.Path}, false
+	case input.local() && kind.usesPgRestore() && (kind.Format == formatDirectory || kind.plainFile()):
+		return []string{input.Path}, false
+	case input.local() && kind.plainFile():
+		return []string{"--file", input.Path}, false
+	default:
+		return nil, true
+	}
+}
+
+func DropDatabase(conn *pgx.Conn, database string) {
+	err := dropDatabase(conn, database)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Drop database failed: %v\n", err)
 		log.Fatal(err)
 	}
 }
 
-func CreateDatabase(conn *pgx.Conn, database string) {
-	query := fmt.Sprintf("CREATE DATABASE %s", database)
+// dropDatabase drops database, failures are returned instead of ending the process
+func dropDatabase(conn *pgx.Conn, database string) error {
+	query := fmt.Sprintf("DROP DATABASE %s;", database)
 	log.Print(query)
+
 	_, err := conn.Exec(context.Background(), query)
+	return err
+}
+
+func CreateDatabase(conn *pgx.Conn, database string) {
+	err := CreateDatabaseWith(conn, database)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Create database failed: %v\n", err)
 		log.Fatal(err)
 	}
 }
 
+// CreateDatabaseWith creates database with options like TEMPLATE = template0 or ENCODING = 'UTF8'
+func CreateDatabaseWith(conn *pgx.Conn, database string, options ...string) error {
+	query := fmt.Sprintf("CREATE DATABASE %s", database)
+	if len(options) > 0 {
+		query += " WITH " + strings.Join(options, " ")
+	}
+	log.Print(query)
+	_, err := conn.Exec(context.Background(), query)
+	return err
+}
+
 func init() {
 	rootCmd.AddCommand(restoreCmd)
 	restoreCmd.PersistentFlags().StringVar(&directory, "dir", ".cappa", "Directory to look dumps files for")
+	restoreCmd.PersistentFlags().StringVarP(&outputFormat, "output", "o", "text", "Output format of the final report : text or json")
+	restoreCmd.PersistentFlags().StringVar(&asSnapshot, "as-snapshot", "", "Restore into a new snapshot with this name, tracked database is left untouched")
+	restoreCmd.PersistentFlags().IntP("jobs", "j", runtime.NumCPU(), "Number of parallel jobs for custom and directory archives")
+
+	restoreCmd.PersistentFlags().StringSlice("table", nil, "Restore data of these tables only (glob patterns, schema.table or table)")
+	restoreCmd.PersistentFlags().StringSlice("exclude-table", nil, "Do not restore data of these tables, they are still created")
+	restoreCmd.PersistentFlags().StringSlice("schema", nil, "Restore objects of these schemas only")
+	restoreCmd.PersistentFlags().Bool("schema-only", false, "Restore schema, no data")
+	restoreCmd.PersistentFlags().Bool("data-only", false, "Restore data into existing tables, database is not recreated")
+	restoreCmd.PersistentFlags().String("template", "", "Template of the restored database (default template0)")
+	restoreCmd.PersistentFlags().String("encoding", "", "Encoding of the restored database (default from dump)")
+	restoreCmd.PersistentFlags().String("lc-collate", "", "LC_COLLATE of the restored database (default from dump)")
+	restoreCmd.PersistentFlags().String("lc-ctype", "", "LC_CTYPE of the restored database (default from dump)")
+	restoreCmd.PersistentFlags().String("locale-provider", "", "Locale provider of the restored database : libc, icu or builtin (default from dump)")
+	restoreCmd.PersistentFlags().String("icu-locale", "", "ICU (or builtin) locale of the restored database (default from dump)")
+	restoreCmd.PersistentFlags().Bool("create-missing", false, "Install extensions the dump needs without asking")
+	restoreCmd.PersistentFlags().StringSlice("ignore-errors", nil, "Restore errors to ignore : missing_role, missing_extension, already_exists, permission_denied, other or text found in the message")
+
+	bindFlag("restore.jobs", restoreCmd.PersistentFlags().Lookup("jobs"))
+	bindFlag("restore.tables", restoreCmd.PersistentFlags().Lookup("table"))
+	bindFlag("restore.exclude_tables", restoreCmd.PersistentFlags().Lookup("exclude-table"))
+	bindFlag("restore.schemas", restoreCmd.PersistentFlags().Lookup("schema"))
+	bindFlag("restore.schema_only", restoreCmd.PersistentFlags().Lookup("schema-only"))
+	bindFlag("restore.data_only", restoreCmd.PersistentFlags().Lookup("data-only"))
+	bindFlag("restore.template", restoreCmd.PersistentFlags().Lookup("template"))
+	bindFlag("restore.encoding", restoreCmd.PersistentFlags().Lookup("encoding"))
+	bindFlag("restore.lc_collate", restoreCmd.PersistentFlags().Lookup("lc-collate"))
+	bindFlag("restore.lc_ctype", restoreCmd.PersistentFlags().Lookup("lc-ctype"))
+	bindFlag("restore.locale_provider", restoreCmd.PersistentFlags().Lookup("locale-provider"))
+	bindFlag("restore.icu_locale", restoreCmd.PersistentFlags().Lookup("icu-locale"))
+	bindFlag("restore.create_missing", restoreCmd.PersistentFlags().Lookup("create-missing"))
+	bindFlag("restore.ignore_errors", restoreCmd.PersistentFlags().Lookup("ignore-errors"))
 }
@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func Test_CanRestoreInParallel(t *testing.T) {
+	cases := map[dumpKind]bool{
+		{Format: formatCustom}:                               true,
+		{Format: formatDirectory}:                            true,
+		{Format: formatTar}:                                  false,
+		{Format: formatPlain}:                                false,
+		{Format: formatCustom, Compression: compressionGzip}: false,
+	}
+	for kind, expected := range cases {
+		if canRestoreInParallel(kind) != expected {
+			t.Fatalf("expected parallel restore of %s to be %v", kind, expected)
+		}
+	}
+}
+
+func Test_LocalFilesAreGivenToToolsAsPaths(t *testing.T) {
+	cases := []struct {
+		input    *dumpInput
+		args     string
+		streamed bool
+	}{
+		{&dumpInput{Path: "prod.dump", Kind: dumpKind{Format: formatCustom}}, "prod.dump", false},
+		{&dumpInput{Path: "prod.tar", Kind: dumpKind{Format: formatTar}}, "prod.tar", false},
+		{&dumpInput{Path: "prod.sql", Kind: dumpKind{Format: formatPlain}}, "--file prod.sql", false},
+		{&dumpInput{Path: "prod.dump.gz", Kind: dumpKind{Format: formatCustom, Compression: compressionGzip}}, "", true},
+		{&dumpInput{Name: "stdin", Kind: dumpKind{Format: formatCustom}}, "", true},
+	}
+	for _, c := range cases {
+		args, streamed := toolInputArgs(c.input, false, 1)
+		if strings.Join(args, " ") != c.args || streamed != c.streamed {
+			t.Errorf("%s : got %v streamed %v", c.input.Kind, args, streamed)
+		}
+	}
+	if args, _ := toolInputArgs(&dumpInput{Path: "prod.dump", Kind: dumpKind{Format: formatCustom}}, true, 4); strings.Join(args, " ") != "--jobs=4 prod.dump" {
+		t.Errorf("unexpected parallel args %v", args)
+	}
+}
+
+func Test_JobsAreSetOnlyWhenAsked(t *testing.T) {
+	if opts := restoreOptionsFromConfig(); opts.JobsSet || opts.Jobs < 1 {
+		t.Errorf("default jobs should not be marked as asked : %+v", opts)
+	}
+	viper.Set("restore.jobs", 4)
+	defer viper.Set("restore.jobs", nil)
+	if opts := restoreOptionsFromConfig(); !opts.JobsSet || opts.Jobs != 4 {
+		t.Errorf("restore.jobs should be marked as asked : %+v", opts)
+	}
+}
+
+func Test_ScanToolLinesReadsLongLinesAndDrains(t *testing.T) {
+	long := strings.Repeat("x", 200*1024)
+	var lines []string
+	err := scanToolLines(strings.NewReader("first\nERROR:  "+long+"\nlast\n"), func(line string) { lines = append(lines, line) })
+	if err != nil || len(lines) != 3 || lines[2] != "last" {
+		t.Fatalf("long line should be read, got %d lines (%v)", len(lines), err)
+	}
+
+	saved := maxToolLine
+	defer func() { maxToolLine = saved }()
+	maxToolLine = 1024
+	r := strings.NewReader("first\n" + long + "\nlast\n")
+	lines = nil
+	err = scanToolLines(r, func(line string) { lines = append(lines, line) })
+	if err != bufio.ErrTooLong || len(lines) != 1 {
+		t.Errorf("line over the limit should be reported, got %d lines (%v)", len(lines), err)
+	}
+	if r.Len() != 0 {
+		t.Errorf("%d bytes left unread, the tool would block", r.Len())
+	}
+}
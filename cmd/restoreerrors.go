@@ -0,0 +1,186 @@
+package cmd
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ttacon/chalk"
+)
+
+// restoreErrorCategory groups errors reported by pg_restore or psql, names are used in the ignore-list
+type restoreErrorCategory string
+
+const (
+	errorMissingRole      restoreErrorCategory = "missing_role"
+	errorMissingExtension restoreErrorCategory = "missing_extension"
+	errorAlreadyExists    restoreErrorCategory = "already_exists"
+	errorPermissionDenied restoreErrorCategory = "permission_denied"
+	errorOther            restoreErrorCategory = "other"
+)
+
+var restoreErrorCategories = []restoreErrorCategory{errorMissingRole, errorMissingExtension, errorAlreadyExists, errorPermissionDenied, errorOther}
+
+// Server errors appear as `pg_restore: error: could not execute query: ERROR:  ...` (12+),
+// `pg_restore: [archiver (db)] could not execute query: ERROR:  ...` (older) or `psql:<stdin>:12: ERROR:  ...`
+var serverErrorPattern = regexp.MustCompile(`(?:ERROR|FATAL):\s+(.*)$`)
+
+// Line printed by pg_restore before an error, `pg_restore: from TOC entry 203; ...` (12+) or
+// `pg_restore: [archiver (db)] Error from TOC entry 203; ...` (older)
+var tocEntryContextPattern = regexp.MustCompile(`^pg_restore: .*(?i:from TOC entry) \d+;`)
+
+var categoryPatterns = []struct {
+	category restoreErrorCategory
+	pattern  *regexp.Regexp
+}{
+	{errorMissingRole, regexp.MustCompile(`role ".*" does not exist`)},
+	{errorMissingExtension, regexp.MustCompile(`extension ".*" (?:is not available|does not exist)|could not open extension control file|could not access file ".*": No such file`)},
+	{errorAlreadyExists, regexp.MustCompile(`already exists`)},
+	{errorPermissionDenied, regexp.MustCompile(`permission denied|must be owner of|must be superuser|must be a member of`)},
+}
+
+// Printed by pg_restore before exiting with 1 because of errors, `pg_restore: warning: errors ignored on restore: 3`
+var ignoredOnRestorePattern = regexp.MustCompile(`errors ignored on restore: (\d+)`)
+
+// restoreError is one error reported by the restore tool
+type restoreError struct {
+	Category restoreErrorCategory
+	Message  string
+	Ignored  bool
+}
+
+// classifyRestoreError tells if a line of tool output is a server error and categorizes it
+func classifyRestoreError(line string) (restoreError, bool) {
+	m := serverErrorPattern.FindStringSubmatch(line)
+	if m == nil {
+		return restoreError{}, false
+	}
+	e := restoreError{Category: errorOther, Message: strings.TrimSpace(m[1])}
+	for _, c := range categoryPatterns {
+		if c.pattern.MatchString(e.Message) {
+			e.Category = c.category
+			break
+		}
+	}
+	return e, true
+}
+
+// restoreErrors collects errors of a restore, ignore holds category names or text found in messages
+type restoreErrors struct {
+	ignore []string
+	errors []restoreError
+	// Errors pg_restore counted, -1 until it tells
+	counted int
+}
+
+func newRestoreErrors(ignore []string) *restoreErrors {
+	return &restoreErrors{ignore: ignore, counted: -1}
+}
+
+// line consumes a line of tool output, ok is false when it is not an error
+func (r *restoreErrors) line(l string) (e restoreError, ok bool) {
+	if m := ignoredOnRestorePattern.FindStringSubmatch(l); m != nil {
+		r.counted, _ = strconv.Atoi(m[1])
+		return e, false
+	}
+	e, ok = classifyRestoreError(l)
+	if !ok {
+		return e, false
+	}
+	e.Ignored = r.ignored(e)
+	r.errors = append(r.errors, e)
+	return e, true
+}
+
+func (r *restoreErrors) ignored(e restoreError) bool {
+	for _, i := range r.ignore {
+		if i == "" {
+			continue
+		}
+		if restoreErrorCategory(i) == e.Category || strings.Contains(e.Message, i) {
+			return true
+		}
+	}
+	return false
+}
+
+// exitError tells if the failed exit of the tool is explained by the errors it reported. pg_restore exits with 1
+// once it counted errors, a count which does not match or a failed psql (run with ON_ERROR_STOP=0) is fatal.
+func (r *restoreErrors) exitError(tool string, waitErr error) error {
+	if waitErr == nil {
+		return nil
+	}
+	if tool == "pg_restore" && r.counted > 0 && r.counted == len(r.errors) {
+		return nil
+	}
+	return fmt.Errorf("%s failed : %s", tool, waitErr)
+}
+
+// unexpected counts errors not in the ignore-list
+func (r *restoreErrors) unexpected() int {
+	count := 0
+	for _, e := range r.errors {
+		if !e.Ignored {
+			count++
+		}
+	}
+	return count
+}
+
+// summary prints error counts by category, ignored ones apart
+func (r *restoreErrors) summary() string {
+	if len(r.errors) == 0 {
+		return ""
+	}
+	counts := map[restoreErrorCategory]int{}
+	ignored := map[restoreErrorCategory]int{}
+	for _, e := range r.errors {
+		if e.Ignored {
+			ignored[e.Category]++
+		} else {
+			counts[e.Category]++
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Restore reported %d error(s), %d unexpected :\n", len(r.errors), r.unexpected())
+	for _, c := range restoreErrorCategories {
+		if counts[c] == 0 && ignored[c] == 0 {
+			continue
+		}
+		line := fmt.Sprintf("  %-18s %d", c, counts[c]+ignored[c])
+		if ignored[c] > 0 {
+			line += fmt.Sprintf(" (%d ignored)", ignored[c])
+		}
+		if counts[c] > 0 {
+			line = chalk.Red.Color(line)
+		}
+		fmt.Fprintln(&b, line)
+	}
+	for _, m := range r.examples(3) {
+		fmt.Fprintf(&b, "  e.g. %s\n", m)
+	}
+	return b.String()
+}
+
+// examples returns the most frequent unexpected messages
+func (r *restoreErrors) examples(max int) []string {
+	counts := map[string]int{}
+	var messages []string
+	for _, e := range r.errors {
+		if e.Ignored {
+			continue
+		}
+		if counts[e.Message] == 0 {
+			messages = append(messages, e.Message)
+		}
+		counts[e.Message]++
+	}
+	sort.SliceStable(messages, func(i, j int) bool { return counts[messages[i]] > counts[messages[j]] })
+	if len(messages) > max {
+		messages = messages[:max]
+	}
+	return messages
+}
@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func Test_ClassifyRestoreError(t *testing.T) {
+	cases := []struct {
+		line     string
+		category restoreErrorCategory
+	}{
+		{`pg_restore: error: could not execute query: ERROR:  role "app_owner" does not exist`, errorMissingRole},
+		{`pg_restore: [archiver (db)] could not execute query: ERROR:  could not open extension control file "/usr/share/postgresql/14/extension/postgis.control": No such file or directory`, errorMissingExtension},
+		{`pg_restore: error: could not execute query: ERROR:  extension "pg_stat_statements" is not available`, errorMissingExtension},
+		{`psql:<stdin>:42: ERROR:  relation "users" already exists`, errorAlreadyExists},
+		{`pg_restore: error: could not execute query: ERROR:  must be owner of extension plpgsql`, errorPermissionDenied},
+		{`pg_restore: error: could not execute query: ERROR:  permission denied for schema audit`, errorPermissionDenied},
+		{`pg_restore: error: could not execute query: ERROR:  syntax error at or near "FOO"`, errorOther},
+	}
+	for _, c := range cases {
+		e, ok := classifyRestoreError(c.line)
+		if !ok {
+			t.Errorf("%q not recognized as an error", c.line)
+			continue
+		}
+		if e.Category != c.category {
+			t.Errorf("%q : got category %s, want %s", c.line, e.Category, c.category)
+		}
+	}
+
+	for _, line := range []string{
+		"pg_restore: creating TABLE \"public.users\"",
+		"pg_restore: warning: errors ignored on restore: 2",
+		"Command was: CREATE EXTENSION postgis;",
+	} {
+		if _, ok := classifyRestoreError(line); ok {
+			t.Errorf("%q should not be an error", line)
+		}
+	}
+}
+
+func Test_RestoreErrorsIgnoreList(t *testing.T) {
+	errs := newRestoreErrors([]string{"already_exists", "plpgsql"})
+	errs.line(`psql:<stdin>:42: ERROR:  relation "users" already exists`)
+	errs.line(`pg_restore: error: could not execute query: ERROR:  must be owner of extension plpgsql`)
+	errs.line(`pg_restore: error: could not execute query: ERROR:  role "app_owner" does not exist`)
+
+	if got := errs.unexpected(); got != 1 {
+		t.Errorf("got %d unexpected errors, want 1", got)
+	}
+	summary := errs.summary()
+	for _, want := range []string{"3 error(s), 1 unexpected", "already_exists", "(1 ignored)", `role "app_owner" does not exist`} {
+		if !strings.Contains(summary, want) {
+			t.Errorf("summary %q should contain %q", summary, want)
+		}
+	}
+
+	if newRestoreErrors(nil).summary() != "" {
+		t.Error("summary should be empty without errors")
+	}
+}
+
+func Test_FatalExitIsNotHiddenByIgnoredErrors(t *testing.T) {
+	exit := errors.New("exit status 1")
+	errs := newRestoreErrors([]string{"already_exists"})
+	errs.line(`pg_restore: error: could not execute query: ERROR:  relation "users" already exists`)
+	errs.line(`pg_restore: error: could not read from input file: end of file`)
+	if errs.unexpected() != 0 {
+		t.Fatal("error should be ignored")
+	}
+	if err := errs.exitError("pg_restore", exit); err == nil {
+		t.Error("pg_restore dying on a truncated archive should fail the restore")
+	}
+	if err := errs.exitError("psql", exit); err == nil {
+		t.Error("failed psql should fail the restore")
+	}
+
+	errs.line(`pg_restore: warning: errors ignored on restore: 1`)
+	if err := errs.exitError("pg_restore", exit); err != nil {
+		t.Errorf("exit caused by ignored errors only should pass, got %v", err)
+	}
+	errs.line(`pg_restore: warning: errors ignored on restore: 2`)
+	if err := errs.exitError("pg_restore", exit); err == nil {
+		t.Error("errors counted by pg_restore but not parsed should fail the restore")
+	}
+}
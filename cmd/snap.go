@@ -7,8 +7,8 @@ import (
 	"github.com/AlecAivazis/survey/v2/terminal"
 	"github.com/jackc/pgx/v4"
 	"github.com/lithammer/shortuuid/v3"
+	"io"
 	"log"
-	"os"
 	"strings"
 
 	"github.com/spf13/cobra"
@@ -21,8 +21,6 @@ var snapshotCmd = &cobra.Command{
 	Args:  cobra.MaximumNArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		var snapshotName string
-		snapUuid := shortuuid.New()
-		toDatabase := fmt.Sprintf("%s_%s", cliName, strings.ToLower(snapUuid))
 
 		if len(args) == 1 {
 			snapshotName = args[0]
@@ -33,40 +31,57 @@ var snapshotCmd = &cobra.Command{
 			}
 			err := survey.AskOne(prompt, &snapshotName, survey.WithValidator(survey.Required))
 			if err == terminal.InterruptErr {
-				fmt.Println("User terminated prompt")
+				fmt.Fprintln(cmd.OutOrStdout(), "User terminated prompt")
 				return nil
 			} else if err != nil {
 				log.Fatal(err)
 			}
 		}
 
-		// Create raw db connexion for copy operation
-		rawConn := createConnection(cliDbUrl)
-		defer rawConn.Close(context.Background())
+		return createSnapshot(snapshotName, cmd.OutOrStdout())
+	},
+}
 
-		// terminate connexion of source DB before copy
-		err := TerminateDatabaseConnections(rawConn, config.Database)
-		if err != nil {
-			log.Fatalf("Impossible to terminate DB connexion : %s", err)
-		}
-		fmt.Println("Copying tracked database, please wait ...")
-		// Copy source DB to snapshot DB
-		copy_database(rawConn, getProjectName(), toDatabase)
-
-		// After (and only after) snapshot DB is created we create tracked db informations
-		trackerConn := createConnection(cliDbUrl)
-		defer trackerConn.Close(context.Background())
-		insertSql := fmt.Sprintf("INSERT INTO snapshots (hash, name, project) VALUES ('%s', '%s', '%s');", strings.ToLower(snapUuid), snapshotName, getProjectName())
-		log.Print(insertSql)
-
-		_, err = trackerConn.Exec(context.Background(), insertSql)
-		if err != nil {
-			log.Fatalf("Error inserting snapshot infos : %s", err)
+// createSnapshot copies tracked database to a new cappa_<hash> database registered as snapshot name
+func createSnapshot(snapshotName string, out io.Writer) error {
+	snapUuid := shortuuid.New()
+	toDatabase := fmt.Sprintf("%s_%s", cliName, strings.ToLower(snapUuid))
+
+	// Create raw db connexion for copy operation
+	rawConn, err := connect(cliDbUrl)
+	if err != nil {
+		return err
+	}
+	defer rawConn.Close(context.Background())
+
+	// terminate connexion of source DB before copy
+	err = TerminateDatabaseConnections(rawConn, config.Database)
+	if err != nil {
+		return fmt.Errorf("Impossible to terminate DB connexion : %s", err)
+	}
+	fmt.Fprintln(out, "Copying tracked database, please wait ...")
+	// Copy source DB to snapshot DB
+	if err := copy_database(rawConn, getProjectName(), toDatabase); err != nil {
+		return err
+	}
+
+	// After (and only after) snapshot DB is created we create tracked db informations
+	trackerConn, err := connect(cliDbUrl)
+	if err != nil {
+		return err
+	}
+	defer trackerConn.Close(context.Background())
+	err = registerSnapshot(trackerConn, strings.ToLower(snapUuid), snapshotName)
+	if err != nil {
+		// An unregistered copy would never be listed nor deleted
+		if dropErr := dropDatabase(rawConn, toDatabase); dropErr != nil {
+			log.Printf("Could not drop %s : %s", toDatabase, dropErr)
 		}
+		return fmt.Errorf("Error inserting snapshot infos : %s", err)
+	}
 
-		fmt.Printf("Snapshot of %s successgully created, name is : %s\n", getProjectName(), snapshotName)
-		return nil
-	},
+	fmt.Fprintf(out, "Snapshot of %s successgully created, name is : %s\n", getProjectName(), snapshotName)
+	return nil
 }
 
 func init() {
@@ -82,13 +97,68 @@ func init() {
 	// snapshotCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
 }
 
-func copy_database(conn *pgx.Conn, from_database string, to_database string) {
+func copy_database(conn *pgx.Conn, from_database string, to_database string) error {
 	query := fmt.Sprintf(`CREATE DATABASE "%s" WITH TEMPLATE "%s";`, to_database, from_database)
 	log.Print(query)
 
 	_, err := conn.Exec(context.Background(), query)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Copy database failed: %v\n", err)
-		log.Fatal(err)
+		return fmt.Errorf("Copy database failed: %v", redact(err.Error()))
+	}
+	return nil
+}
+
+// findSnapshots returns snapshots of current project with this name
+func findSnapshots(name string) ([]Snapshot, error) {
+	conn, err := connect(cliDbUrl)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close(context.Background())
+	list, err := listSnapshots(conn)
+	if err != nil {
+		return nil, err
+	}
+	var found []Snapshot
+	for _, snap := range list {
+		if snap.Name == name {
+			found = append(found, snap)
+		}
+	}
+	return found, nil
+}
+
+// removeSnapshot drops the database of a snapshot and forgets it in tracker database
+func removeSnapshot(snap Snapshot) error {
+	defaultDbConn, err := connect(defaultDbUrl)
+	if err != nil {
+		return err
+	}
+	defer defaultDbConn.Close(context.Background())
+	cliDbConn, err := connect(cliDbUrl)
+	if err != nil {
+		return err
+	}
+	defer cliDbConn.Close(context.Background())
+
+	database := fmt.Sprintf("%s_%s", cliName, snap.Hash)
+	if err := TerminateDatabaseConnections(defaultDbConn, database); err != nil {
+		return err
 	}
+	if err := dropDatabase(defaultDbConn, database); err != nil {
+		return err
+	}
+	deleteSql := fmt.Sprintf("DELETE FROM snapshots WHERE id=%d;", snap.Id)
+	log.Print(deleteSql)
+	_, err = cliDbConn.Exec(context.Background(), deleteSql)
+	return err
+}
+
+// registerSnapshot records in tracker database that cappa_<hash> database is a snapshot of current project
+func registerSnapshot(conn *pgx.Conn, hash string, name string) error {
+	insertSql := "INSERT INTO snapshots (hash, name, project) VALUES ($1, $2, $3);"
+	log.Printf("%s [%s, %s, %s]", insertSql, hash, name, getProjectName())
+
+	_, err := conn.Exec(context.Background(), insertSql, hash, name, getProjectName())
+	return err
 }
@@ -0,0 +1,189 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// dumpInput gives access to a dump, either a local file or directory tools can read by themselves,
+// or a one-shot stream (stdin, http, s3) which is never written to disk.
+type dumpInput struct {
+	Name string
+	// Path is set for local dumps only
+	Path string
+	// Size of raw (possibly compressed) content, 0 when unknown
+	Size int64
+	Kind dumpKind
+
+	stdin   bool
+	raw     *countingReader
+	content io.ReadCloser
+	// Uncompressed content consumed from stream while inspecting it, replayed to the restore tool
+	replay bytes.Buffer
+}
+
+// local tells if tools can read the dump by themselves (seek, parallel restore).
+// Encrypted dump files are read as streams, they are decrypted once.
+func (in *dumpInput) local() bool {
+	return in.Path != "" && in.content == nil
+}
+
+// inspect lets fn read uncompressed content from its beginning without consuming a stream
+func (in *dumpInput) inspect(fn func(r io.Reader) error) error {
+	if in.local() {
+		dump, err := openDump(in.Path, in.Kind)
+		if err != nil {
+			return err
+		}
+		defer dump.Close()
+		return fn(dump)
+	}
+	seen := bytes.NewReader(in.replay.Bytes())
+	return fn(io.MultiReader(seen, io.TeeReader(in.content, &in.replay)))
+}
+
+// stream returns decrypted and uncompressed content from its beginning, bytes read are counted in raw.
+// A stream source can only be restored once.
+func (in *dumpInput) stream() (io.ReadCloser, error) {
+	if in.local() {
+		f, err := os.Open(in.Path)
+		if err != nil {
+			return nil, err
+		}
+		in.raw = &countingReader{r: f}
+		return decode(&stackedReadCloser{Reader: in.raw, closers: []io.Closer{f}}, in.Kind)
+	}
+	return &stackedReadCloser{Reader: io.MultiReader(&in.replay, in.content), closers: []io.Closer{in.content}}, nil
+}
+
+func (in *dumpInput) Close() error {
+	if in.content != nil {
+		return in.content.Close()
+	}
+	return nil
+}
+
+// countingReader counts bytes read and reports them to a progress bar once attached
+type countingReader struct {
+	r        io.Reader
+	n        int64
+	progress *restoreProgress
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	if c.progress != nil {
+		c.progress.addBytes(int64(n))
+	}
+	return n, err
+}
+
+// attach reports bytes read so far and next ones to p
+func (c *countingReader) attach(p *restoreProgress) {
+	c.progress = p
+	p.addBytes(c.n)
+}
+
+// openSource opens a dump from a local path, '-' for stdin, an http(s)://, s3://bucket/key, gs://bucket/key
+// or sftp://host/path url
+func openSource(uri string) (*dumpInput, error) {
+	var raw io.ReadCloser
+	var size int64
+	name := uri
+
+	u, err := url.Parse(uri)
+	switch {
+	case uri == "-":
+		raw = os.Stdin
+		name = "stdin"
+	case err == nil && (u.Scheme == "http" || u.Scheme == "https"):
+		resp, err := http.Get(uri)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("could not download %s : %s", maskUrlPassword(uri), resp.Status)
+		}
+		raw = resp.Body
+		name = maskUrlPassword(uri)
+		if resp.ContentLength > 0 {
+			size = resp.ContentLength
+		}
+	case err == nil && (u.Scheme == "s3" || u.Scheme == "gs" || u.Scheme == "sftp"):
+		key := strings.TrimPrefix(u.Path, "/")
+		if u.Host == "" || key == "" {
+			return nil, fmt.Errorf("%s source must look like %s://host/key", u.Scheme, u.Scheme)
+		}
+		// Storage of the host, the object is the whole path
+		root := *u
+		root.Path = "/"
+		store, _, err := openStorage(sourceConfig{Url: root.String(), Aws: awsConfigFromViper()})
+		if err != nil {
+			return nil, err
+		}
+		obj, err := store.Stat(remoteObject{key: key})
+		if err == nil {
+			raw, err = store.Open(obj, 0)
+		}
+		if err != nil {
+			store.Close()
+			return nil, fmt.Errorf("could not open %s : %s", maskUrlPassword(uri), err)
+		}
+		raw = &stackedReadCloser{Reader: raw, closers: []io.Closer{raw, store}}
+		name = maskUrlPassword(uri)
+		size = obj.size
+	default:
+		return openLocalSource(uri)
+	}
+
+	counted := &countingReader{r: raw}
+	kind, content, err := sniffDump(&stackedReadCloser{Reader: counted, closers: []io.Closer{raw}})
+	if err != nil {
+		raw.Close()
+		return nil, err
+	}
+	return &dumpInput{Name: name, Size: size, Kind: kind, stdin: uri == "-", raw: counted, content: content}, nil
+}
+
+func openLocalSource(path string) (*dumpInput, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() && encryptionOf(path) != encryptionNone {
+		return openEncryptedFile(path, info.Size())
+	}
+	kind, err := detectDump(path)
+	if err != nil {
+		return nil, err
+	}
+	input := &dumpInput{Name: filepath.Base(path), Path: path, Kind: kind}
+	if !info.IsDir() {
+		input.Size = info.Size()
+	}
+	return input, nil
+}
+
+// openEncryptedFile decrypts a dump file as a stream : inspecting then restoring it runs gpg or age,
+// or asks for a passphrase, only once
+func openEncryptedFile(path string, size int64) (*dumpInput, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	counted := &countingReader{r: f}
+	kind, content, err := sniffDump(&stackedReadCloser{Reader: counted, closers: []io.Closer{f}})
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &dumpInput{Name: filepath.Base(path), Path: path, Size: size, Kind: kind, raw: counted, content: content}, nil
+}
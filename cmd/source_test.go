@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_HttpSourceIsStreamedAndReplayed(t *testing.T) {
+	content := []byte("PGDMP\x01\x0e\x00" + strings.Repeat("some archive content ", 2000))
+	compressed := gzipped(content)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(compressed)
+	}))
+	defer server.Close()
+
+	input, err := openSource(server.URL + "/app.dump.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer input.Close()
+	if input.local() {
+		t.Error("http source should be streamed")
+	}
+	if want := (dumpKind{Format: formatCustom, Compression: compressionGzip}); input.Kind != want {
+		t.Errorf("got kind %s, want %s", input.Kind, want)
+	}
+
+	v, ok, err := dumpArchiveVersion(input)
+	if err != nil || !ok || v.String() != "1.14-0" {
+		t.Errorf("got archive version %s, %v, %v", v, ok, err)
+	}
+
+	// Inspecting twice still starts from the beginning
+	err = input.inspect(func(r io.Reader) error {
+		head := make([]byte, 100)
+		_, err := io.ReadFull(r, head)
+		if !bytes.Equal(head, content[:100]) {
+			t.Error("inspected content does not start at the beginning")
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stream, err := input.stream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored, err := ioutil.ReadAll(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(restored, content) {
+		t.Errorf("streamed %d bytes, want %d", len(restored), len(content))
+	}
+	if input.raw.n != int64(len(compressed)) {
+		t.Errorf("counted %d raw bytes, want %d", input.raw.n, len(compressed))
+	}
+}
+
+func Test_HttpSourceFailsOnBadStatus(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	if _, err := openSource(server.URL + "/missing.dump"); err == nil {
+		t.Error("expected an error for a missing dump")
+	}
+}
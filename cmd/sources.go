@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// sourceConfig is where grab finds dumps, a [sources.<name>] table of the config file, e.g.
+//
+//	[sources.prod-hourly]
+//	url = "s3://backups/prod/hourly/"
+//	aws_profile = "backups"
+//	match = "*.dump"
+type sourceConfig struct {
+	Name   string
+	Url    string `mapstructure:"url"`
+	Prefix string `mapstructure:"prefix"`
+	// Defaults of grab flags
+	Match string `mapstructure:"match"`
+	Regex string `mapstructure:"regex"`
+	Dest  string `mapstructure:"dest"`
+	// S3 (any key of grab) and Google Cloud Storage
+	Aws             AwsConfig `mapstructure:"-"`
+	Endpoint        string    `mapstructure:"endpoint"`
+	CredentialsFile string    `mapstructure:"credentials_file"`
+	// SFTP
+	IdentityFile string `mapstructure:"identity_file"`
+	KnownHosts   string `mapstructure:"known_hosts"`
+	Password     string `mapstructure:"password"`
+}
+
+// sourceNames lists sources of the config file
+func sourceNames() []string {
+	var names []string
+	for name := range viper.GetStringMap("sources") {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// loadSource returns a named source, or a source for an url or path given instead of a name.
+// Settings of the source win over global ones.
+func loadSource(name string) (sourceConfig, error) {
+	source := sourceConfig{Name: name, Aws: awsConfigFromViper()}
+	key := "sources." + strings.ToLower(name)
+	if !viper.IsSet(key) {
+		if !strings.Contains(name, "://") && !strings.ContainsAny(name, `/\`) {
+			return source, fmt.Errorf("unknown source %q, configured sources : %s", name, strings.Join(sourceNames(), ", "))
+		}
+		source.Url = name
+		return source, nil
+	}
+
+	if err := viper.UnmarshalKey(key, &source); err != nil {
+		return source, fmt.Errorf("invalid source %s : %s", name, err)
+	}
+	// Only keys set in the source replace global aws settings
+	if err := viper.UnmarshalKey(key, &source.Aws); err != nil {
+		return source, fmt.Errorf("invalid source %s : %s", name, err)
+	}
+	if source.Aws.Endpoint != "" && source.Aws.Region == "" {
+		source.Aws.Region = "us-east-1"
+	}
+	if source.Url == "" {
+		return source, fmt.Errorf("source %s has no url", name)
+	}
+	return source, nil
+}
+
+// bucketSource is the source given by bucket and prefix settings, when no source is named
+func bucketSource() (sourceConfig, error) {
+	aco := awsConfigFromViper()
+	if aco.Bucket == "" {
+		return sourceConfig{}, fmt.Errorf("You must provide a bucket value or a --source")
+	}
+	return sourceConfig{Url: "s3://" + aco.Bucket, Prefix: aco.Prefix, Aws: aco}, nil
+}
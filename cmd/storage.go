@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	errObjectNotFound = errors.New("object not found")
+	errObjectChanged  = errors.New("object changed since it was listed")
+	// Wrapped in errors of refused requests, the original error is kept
+	errAccessDenied = errors.New("access denied")
+)
+
+// storage is somewhere dumps are listed, downloaded from and uploaded to. Keys are slash separated,
+// relative to the root of the storage (a bucket, or the directory of the source url).
+type storage interface {
+	// List returns objects whose key starts with prefix, newest first
+	List(prefix string) ([]remoteObject, error)
+	// Stat returns size, date and etag of an object, errObjectNotFound when it does not exist
+	Stat(obj remoteObject) (remoteObject, error)
+	// Open reads an object from offset. With the etag of Stat, errObjectChanged is returned when it was replaced since.
+	Open(obj remoteObject, offset int64) (io.ReadCloser, error)
+	// Put writes an object, readers never see a partial one
+	Put(key string, r io.Reader) error
+	Close() error
+	// String is the url of the storage, without password
+	String() string
+}
+
+// versionedStorage keeps previous versions of objects
+type versionedStorage interface {
+	storage
+	ListVersions(prefix string) ([]remoteObject, error)
+}
+
+// openStorage connects to the storage of a source and returns it with the prefix to list
+func openStorage(source sourceConfig) (storage, string, error) {
+	u, err := url.Parse(source.Url)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid source url %s : %s", maskUrlPassword(source.Url), err)
+	}
+	switch u.Scheme {
+	case "s3":
+		return openS3Storage(u, source)
+	case "gs":
+		return openGcsStorage(u, source)
+	case "sftp":
+		store, err := openSftpStorage(u, source)
+		return store, source.Prefix, err
+	case "http", "https":
+		store, err := openHttpStorage(u)
+		return store, source.Prefix, err
+	case "file":
+		return &localStorage{root: u.Path}, source.Prefix, nil
+	case "":
+		// A plain path, local disk or a mounted share
+		return &localStorage{root: source.Url}, source.Prefix, nil
+	default:
+		return nil, "", fmt.Errorf("unsupported source url %s, expected s3://, gs://, sftp://, http(s)://, file:// or a path", maskUrlPassword(source.Url))
+	}
+}
+
+// bucketPrefix returns the prefix given in a bucket url, s3://bucket/db/ lists keys under db/
+func bucketPrefix(u *url.URL, source sourceConfig) string {
+	return strings.TrimPrefix(u.Path, "/") + source.Prefix
+}
+
+// cleanKey makes a key relative to the storage root, .. cannot get out of it
+func cleanKey(key string) string {
+	return strings.TrimPrefix(path.Clean("/"+key), "/")
+}
+
+// fileEtag identifies a version of a file on storages without etags
+func fileEtag(size int64, modified time.Time) string {
+	return fmt.Sprintf("%x-%x", size, modified.UnixNano())
+}
+
+// newestFirst sorts objects by last modification date, newest first
+func newestFirst(objects []remoteObject) []remoteObject {
+	sort.SliceStable(objects, func(i, j int) bool { return objects[i].updated.After(objects[j].updated) })
+	return objects
+}
@@ -0,0 +1,179 @@
+package cmd
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mitchellh/go-homedir"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+const (
+	gcsEndpoint = "https://storage.googleapis.com"
+	gcsScope    = "https://www.googleapis.com/auth/devstorage.read_write"
+)
+
+// gcsStorage is a Google Cloud Storage bucket, reached with its JSON api
+type gcsStorage struct {
+	bucket   string
+	endpoint string
+	client   *http.Client
+}
+
+// gcsObject is the object resource of the JSON api
+type gcsObject struct {
+	Name       string    `json:"name"`
+	Size       string    `json:"size"`
+	Updated    time.Time `json:"updated"`
+	Md5Hash    string    `json:"md5Hash"`
+	Generation string    `json:"generation"`
+}
+
+// openGcsStorage uses credentials_file (a service account key) or application default credentials.
+// With a custom endpoint (fake-gcs-server) and no credentials_file, requests are anonymous.
+func openGcsStorage(u *url.URL, source sourceConfig) (storage, string, error) {
+	if u.Host == "" {
+		return nil, "", fmt.Errorf("gs source must look like gs://bucket/prefix")
+	}
+	s := &gcsStorage{bucket: u.Host, endpoint: strings.TrimSuffix(source.Endpoint, "/"), client: http.DefaultClient}
+	ctx := context.Background()
+	switch {
+	case source.CredentialsFile != "":
+		file, err := homedir.Expand(source.CredentialsFile)
+		if err != nil {
+			return nil, "", err
+		}
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, "", err
+		}
+		creds, err := google.CredentialsFromJSON(ctx, content, gcsScope)
+		if err != nil {
+			return nil, "", err
+		}
+		s.client = oauth2.NewClient(ctx, creds.TokenSource)
+	case s.endpoint == "":
+		client, err := google.DefaultClient(ctx, gcsScope)
+		if err != nil {
+			return nil, "", fmt.Errorf("no Google Cloud credentials, set credentials_file or run gcloud auth application-default login : %s", err)
+		}
+		s.client = client
+	}
+	if s.endpoint == "" {
+		s.endpoint = gcsEndpoint
+	}
+	return s, bucketPrefix(u, source), nil
+}
+
+func (s *gcsStorage) objectUrl(key string) string {
+	// Slashes of object names are escaped too
+	return fmt.Sprintf("%s/storage/v1/b/%s/o/%s", s.endpoint, url.PathEscape(s.bucket), url.PathEscape(key))
+}
+
+func (s *gcsStorage) List(prefix string) ([]remoteObject, error) {
+	var objects []remoteObject
+	pageToken := ""
+	for {
+		query := url.Values{"prefix": {prefix}}
+		if pageToken != "" {
+			query.Set("pageToken", pageToken)
+		}
+		var page struct {
+			Items         []gcsObject `json:"items"`
+			NextPageToken string      `json:"nextPageToken"`
+		}
+		if err := s.getJson(fmt.Sprintf("%s/storage/v1/b/%s/o?%s", s.endpoint, url.PathEscape(s.bucket), query.Encode()), &page); err != nil {
+			return nil, err
+		}
+		for _, item := range page.Items {
+			objects = append(objects, item.remoteObject())
+		}
+		if page.NextPageToken == "" {
+			return newestFirst(objects), nil
+		}
+		pageToken = page.NextPageToken
+	}
+}
+
+func (s *gcsStorage) Stat(obj remoteObject) (remoteObject, error) {
+	var item gcsObject
+	if err := s.getJson(s.objectUrl(obj.key), &item); err != nil {
+		return obj, err
+	}
+	return item.remoteObject(), nil
+}
+
+// Open downloads the generation of obj, errObjectChanged when the object was replaced since
+func (s *gcsStorage) Open(obj remoteObject, offset int64) (io.ReadCloser, error) {
+	query := url.Values{"alt": {"media"}}
+	if obj.etag != "" {
+		query.Set("ifGenerationMatch", obj.etag)
+	}
+	req, err := http.NewRequest(http.MethodGet, s.objectUrl(obj.key)+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := httpStatusError(resp); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *gcsStorage) Put(key string, r io.Reader) error {
+	query := url.Values{"uploadType": {"media"}, "name": {key}}
+	resp, err := s.client.Post(fmt.Sprintf("%s/upload/storage/v1/b/%s/o?%s", s.endpoint, url.PathEscape(s.bucket), query.Encode()), "application/octet-stream", r)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return httpStatusError(resp)
+}
+
+func (s *gcsStorage) Close() error {
+	return nil
+}
+
+func (s *gcsStorage) String() string {
+	return "gs://" + s.bucket
+}
+
+func (s *gcsStorage) getJson(u string, v interface{}) error {
+	resp, err := s.client.Get(u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := httpStatusError(resp); err != nil {
+		return err
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// remoteObject uses the generation as etag, md5Hash is base64
+func (o gcsObject) remoteObject() remoteObject {
+	size, _ := strconv.ParseInt(o.Size, 10, 64)
+	obj := remoteObject{key: o.Name, updated: o.Updated, size: size, etag: o.Generation}
+	if sum, err := base64.StdEncoding.DecodeString(o.Md5Hash); err == nil && len(sum) > 0 {
+		obj.md5 = hex.EncodeToString(sum)
+	}
+	return obj
+}
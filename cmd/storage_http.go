@@ -0,0 +1,184 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Links of directory indexes served by nginx autoindex, Apache mod_autoindex or python -m http.server
+var indexLinkPattern = regexp.MustCompile(`(?i)<a\s[^>]*href\s*=\s*["']([^"']+)["']`)
+
+// httpStorage is a directory index served over http, objects are uploaded with PUT (WebDAV)
+type httpStorage struct {
+	base   *url.URL
+	client *http.Client
+}
+
+func openHttpStorage(u *url.URL) (storage, error) {
+	base := *u
+	if !strings.HasSuffix(base.Path, "/") {
+		base.Path += "/"
+	}
+	return &httpStorage{base: &base, client: http.DefaultClient}, nil
+}
+
+func (s *httpStorage) url(key string) string {
+	return s.base.ResolveReference(&url.URL{Path: cleanKey(key)}).String()
+}
+
+// List reads the index of the base directory and of its sub directories, then asks each file its size and date
+func (s *httpStorage) List(prefix string) ([]remoteObject, error) {
+	var objects []remoteObject
+	dirs := []string{""}
+	for len(dirs) > 0 {
+		dir := dirs[0]
+		dirs = dirs[1:]
+		links, err := s.index(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range links {
+			if strings.HasSuffix(key, "/") {
+				dirs = append(dirs, key)
+				continue
+			}
+			if !strings.HasPrefix(key, prefix) {
+				continue
+			}
+			obj, err := s.Stat(remoteObject{key: key})
+			if err != nil {
+				return nil, fmt.Errorf("%s : %s", key, err)
+			}
+			objects = append(objects, obj)
+		}
+	}
+	return newestFirst(objects), nil
+}
+
+// index returns keys linked from the index page of dir, only those below it
+func (s *httpStorage) index(dir string) ([]string, error) {
+	dirUrl := s.base.ResolveReference(&url.URL{Path: dir})
+	resp, err := s.client.Get(dirUrl.String())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("could not read index of %s : %s", maskUrlPassword(dirUrl.String()), resp.Status)
+	}
+	page, err := ioutil.ReadAll(io.LimitReader(resp.Body, 16<<20))
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	var keys []string
+	for _, match := range indexLinkPattern.FindAllSubmatch(page, -1) {
+		link, err := url.Parse(string(match[1]))
+		// Sort links (?C=M;O=A) and anchors are not files
+		if err != nil || link.RawQuery != "" || link.Fragment != "" {
+			continue
+		}
+		target := dirUrl.ResolveReference(link)
+		// Parent directory and links to other sites
+		if target.Host != s.base.Host || !strings.HasPrefix(target.Path, dirUrl.Path) || target.Path == dirUrl.Path {
+			continue
+		}
+		key := strings.TrimPrefix(target.Path, s.base.Path)
+		if !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+func (s *httpStorage) Stat(obj remoteObject) (remoteObject, error) {
+	resp, err := s.client.Head(s.url(obj.key))
+	if err != nil {
+		return obj, err
+	}
+	resp.Body.Close()
+	if err := httpStatusError(resp); err != nil {
+		return obj, err
+	}
+	obj.size = resp.ContentLength
+	obj.updated, _ = http.ParseTime(resp.Header.Get("Last-Modified"))
+	// If-Range takes an ETag or a date, servers without ETags send Last-Modified
+	obj.etag = resp.Header.Get("ETag")
+	if obj.etag == "" || strings.HasPrefix(obj.etag, "W/") {
+		obj.etag = resp.Header.Get("Last-Modified")
+	}
+	return obj, nil
+}
+
+func (s *httpStorage) Open(obj remoteObject, offset int64) (io.ReadCloser, error) {
+	req, err := http.NewRequest(http.MethodGet, s.url(obj.key), nil)
+	if err != nil {
+		return nil, err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		if obj.etag != "" {
+			req.Header.Set("If-Range", obj.etag)
+		}
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := httpStatusError(resp); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	// Whole content is sent back when the object changed since etag
+	if offset > 0 && resp.StatusCode != http.StatusPartialContent {
+		resp.Body.Close()
+		return nil, errObjectChanged
+	}
+	return resp.Body, nil
+}
+
+func (s *httpStorage) Put(key string, r io.Reader) error {
+	req, err := http.NewRequest(http.MethodPut, s.url(key), r)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("could not upload %s : %s", key, resp.Status)
+	}
+	return nil
+}
+
+func (s *httpStorage) Close() error {
+	return nil
+}
+
+func (s *httpStorage) String() string {
+	return maskUrlPassword(strings.TrimSuffix(s.base.String(), "/"))
+}
+
+// httpStatusError translates failed responses to storage errors
+func httpStatusError(resp *http.Response) error {
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return errObjectNotFound
+	case resp.StatusCode == http.StatusPreconditionFailed:
+		return errObjectChanged
+	case resp.StatusCode == http.StatusForbidden:
+		return fmt.Errorf("%w : %s %s", errAccessDenied, resp.Request.Method, maskUrlPassword(resp.Request.URL.String()))
+	case resp.StatusCode >= 300:
+		return fmt.Errorf("%s %s : %s", resp.Request.Method, maskUrlPassword(resp.Request.URL.String()), resp.Status)
+	}
+	return nil
+}
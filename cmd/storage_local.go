@@ -0,0 +1,127 @@
+package cmd
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// localStorage is a directory of the local disk, or of a mounted share (NFS, SMB)
+type localStorage struct {
+	root string
+}
+
+func (s *localStorage) path(key string) string {
+	return filepath.Join(s.root, filepath.FromSlash(cleanKey(key)))
+}
+
+// List skips files cappa keeps next to dumps, a source can be the dumps directory of another project
+func (s *localStorage) List(prefix string) ([]remoteObject, error) {
+	var objects []remoteObject
+	err := filepath.Walk(s.root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if p != s.root && cappaFile(info.Name()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(s.root, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if strings.HasPrefix(key, prefix) {
+			objects = append(objects, fileObject(key, info))
+		}
+		return nil
+	})
+	return newestFirst(objects), err
+}
+
+func (s *localStorage) Stat(obj remoteObject) (remoteObject, error) {
+	info, err := os.Stat(s.path(obj.key))
+	if os.IsNotExist(err) {
+		return obj, errObjectNotFound
+	}
+	if err != nil {
+		return obj, err
+	}
+	return fileObject(obj.key, info), nil
+}
+
+func (s *localStorage) Open(obj remoteObject, offset int64) (io.ReadCloser, error) {
+	f, err := os.Open(s.path(obj.key))
+	if os.IsNotExist(err) {
+		return nil, errObjectNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return seekUnchanged(f, obj, offset)
+}
+
+func (s *localStorage) Put(key string, r io.Reader) error {
+	dest := s.path(key)
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(dest), ".cappa-put-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dest)
+}
+
+func (s *localStorage) Close() error {
+	return nil
+}
+
+func (s *localStorage) String() string {
+	return s.root
+}
+
+// fileObject describes a file of a filesystem like storage
+func fileObject(key string, info os.FileInfo) remoteObject {
+	return remoteObject{key: key, updated: info.ModTime(), size: info.Size(), etag: fileEtag(info.Size(), info.ModTime())}
+}
+
+// seekableFile is an open file of local or sftp storages
+type seekableFile interface {
+	io.ReadSeeker
+	io.Closer
+	Stat() (os.FileInfo, error)
+}
+
+// seekUnchanged moves f to offset, after checking it still is the file obj was listed from
+func seekUnchanged(f seekableFile, obj remoteObject, offset int64) (io.ReadCloser, error) {
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if obj.etag != "" && fileEtag(info.Size(), info.ModTime()) != obj.etag {
+		f.Close()
+		return nil, errObjectChanged
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
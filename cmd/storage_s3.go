@@ -0,0 +1,110 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// s3Storage is a bucket of AWS or of a S3 compatible storage
+type s3Storage struct {
+	bucket string
+	sess   *session.Session
+}
+
+func openS3Storage(u *url.URL, source sourceConfig) (storage, string, error) {
+	if u.Host == "" {
+		return nil, "", fmt.Errorf("You must provide a bucket value")
+	}
+	aco := source.Aws
+	aco.Bucket = u.Host
+	sess, err := getAwsSession(&aco)
+	if err != nil {
+		return nil, "", err
+	}
+	// Region can also come from the profile
+	if aws.StringValue(sess.Config.Region) == "" {
+		return nil, "", fmt.Errorf("You must provide a region value")
+	}
+	return &s3Storage{bucket: u.Host, sess: sess}, bucketPrefix(u, source), nil
+}
+
+func (s *s3Storage) List(prefix string) ([]remoteObject, error) {
+	return readBucket(s.bucket, prefix, s.sess)
+}
+
+func (s *s3Storage) ListVersions(prefix string) ([]remoteObject, error) {
+	return readVersions(s.bucket, prefix, s.sess)
+}
+
+func (s *s3Storage) Stat(obj remoteObject) (remoteObject, error) {
+	head, err := s3.New(s.sess).HeadObject(&s3.HeadObjectInput{Bucket: aws.String(s.bucket), Key: aws.String(obj.key), VersionId: optionalString(obj.versionId)})
+	if err != nil {
+		return obj, s3Error(err)
+	}
+	obj.size = aws.Int64Value(head.ContentLength)
+	obj.updated = aws.TimeValue(head.LastModified)
+	obj.etag = aws.StringValue(head.ETag)
+	obj.md5 = md5OfEtag(obj.etag)
+	return obj, nil
+}
+
+func (s *s3Storage) Open(obj remoteObject, offset int64) (io.ReadCloser, error) {
+	input := &s3.GetObjectInput{Bucket: aws.String(s.bucket), Key: aws.String(obj.key), VersionId: optionalString(obj.versionId), IfMatch: optionalString(obj.etag)}
+	if offset > 0 {
+		input.Range = aws.String(fmt.Sprintf("bytes=%d-", offset))
+	}
+	out, err := s3.New(s.sess).GetObject(input)
+	if err != nil {
+		return nil, s3Error(err)
+	}
+	return out.Body, nil
+}
+
+func (s *s3Storage) Put(key string, r io.Reader) error {
+	_, err := s3manager.NewUploader(s.sess).Upload(&s3manager.UploadInput{Bucket: aws.String(s.bucket), Key: aws.String(key), Body: r})
+	return err
+}
+
+func (s *s3Storage) Close() error {
+	return nil
+}
+
+func (s *s3Storage) String() string {
+	return "s3://" + s.bucket
+}
+
+// s3Error translates status codes of failed requests to storage errors
+func s3Error(err error) error {
+	reqErr, ok := err.(awserr.RequestFailure)
+	if !ok {
+		return err
+	}
+	switch reqErr.StatusCode() {
+	case http.StatusNotFound:
+		return errObjectNotFound
+	// Wrong role or KMS key, or a missing key without s3:ListBucket permission
+	case http.StatusForbidden:
+		return fmt.Errorf("%w : %s", errAccessDenied, err)
+	case http.StatusPreconditionFailed:
+		return errObjectChanged
+	}
+	return err
+}
+
+// md5OfEtag returns the md5 of single part uploads, their ETag. Multipart ones end with -<parts>.
+func md5OfEtag(etag string) string {
+	plain := strings.Trim(etag, `"`)
+	if md5EtagPattern.MatchString(plain) {
+		return plain
+	}
+	return ""
+}
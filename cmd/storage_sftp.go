@@ -0,0 +1,193 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"os/user"
+	"path"
+	"strings"
+
+	"github.com/mitchellh/go-homedir"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// sftpStorage is a directory of a ssh server
+type sftpStorage struct {
+	url    string
+	root   string
+	conn   *ssh.Client
+	client *sftp.Client
+}
+
+// openSftpStorage connects to sftp://[user[:password]@]host[:port]/path. Host key must be in known_hosts,
+// authentication uses password, identity_file and ssh-agent, in that order.
+func openSftpStorage(u *url.URL, source sourceConfig) (storage, error) {
+	config := &ssh.ClientConfig{User: u.User.Username()}
+	if config.User == "" {
+		current, err := user.Current()
+		if err != nil {
+			return nil, err
+		}
+		config.User = current.Username
+	}
+
+	knownHosts := source.KnownHosts
+	if knownHosts == "" {
+		knownHosts = "~/.ssh/known_hosts"
+	}
+	knownHosts, err := homedir.Expand(knownHosts)
+	if err != nil {
+		return nil, err
+	}
+	config.HostKeyCallback, err = knownhosts.New(knownHosts)
+	if err != nil {
+		return nil, fmt.Errorf("could not read known hosts : %s", err)
+	}
+
+	if password, set := u.User.Password(); set {
+		config.Auth = append(config.Auth, ssh.Password(password))
+	} else if source.Password != "" {
+		config.Auth = append(config.Auth, ssh.Password(source.Password))
+	}
+	if source.IdentityFile != "" {
+		signer, err := readIdentityFile(source.IdentityFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Auth = append(config.Auth, ssh.PublicKeys(signer))
+	}
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		if agentConn, err := net.Dial("unix", sock); err == nil {
+			defer agentConn.Close()
+			config.Auth = append(config.Auth, ssh.PublicKeysCallback(agent.NewClient(agentConn).Signers))
+		} else {
+			log.Printf("Could not reach ssh-agent : %s", err)
+		}
+	}
+
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "22")
+	}
+	conn, err := ssh.Dial("tcp", host, config)
+	if err != nil {
+		return nil, err
+	}
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	root := u.Path
+	if root == "" {
+		root = "."
+	}
+	return &sftpStorage{url: maskUrlPassword(u.String()), root: root, conn: conn, client: client}, nil
+}
+
+// readIdentityFile reads a private key, protected ones must be loaded in ssh-agent
+func readIdentityFile(file string) (ssh.Signer, error) {
+	file, err := homedir.Expand(file)
+	if err != nil {
+		return nil, err
+	}
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.ParsePrivateKey(content)
+	if _, ok := err.(*ssh.PassphraseMissingError); ok {
+		return nil, fmt.Errorf("%s is protected by a passphrase, add it to ssh-agent instead", file)
+	}
+	return signer, err
+}
+
+func (s *sftpStorage) path(key string) string {
+	return path.Join(s.root, cleanKey(key))
+}
+
+func (s *sftpStorage) List(prefix string) ([]remoteObject, error) {
+	var objects []remoteObject
+	rootDir := strings.TrimSuffix(s.root, "/") + "/"
+	walker := s.client.Walk(s.root)
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			return nil, err
+		}
+		info := walker.Stat()
+		if !info.Mode().IsRegular() {
+			continue
+		}
+		key := strings.TrimPrefix(walker.Path(), rootDir)
+		if strings.HasPrefix(key, prefix) {
+			objects = append(objects, fileObject(key, info))
+		}
+	}
+	return newestFirst(objects), nil
+}
+
+func (s *sftpStorage) Stat(obj remoteObject) (remoteObject, error) {
+	info, err := s.client.Stat(s.path(obj.key))
+	if os.IsNotExist(err) {
+		return obj, errObjectNotFound
+	}
+	if err != nil {
+		return obj, err
+	}
+	return fileObject(obj.key, info), nil
+}
+
+func (s *sftpStorage) Open(obj remoteObject, offset int64) (io.ReadCloser, error) {
+	f, err := s.client.Open(s.path(obj.key))
+	if os.IsNotExist(err) {
+		return nil, errObjectNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return seekUnchanged(f, obj, offset)
+}
+
+func (s *sftpStorage) Put(key string, r io.Reader) error {
+	dest := s.path(key)
+	if err := s.client.MkdirAll(path.Dir(dest)); err != nil {
+		return err
+	}
+	tmp := path.Join(path.Dir(dest), ".cappa-put-"+path.Base(dest))
+	f, err := s.client.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		s.client.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		s.client.Remove(tmp)
+		return err
+	}
+	// Plain sftp rename fails when destination exists
+	if err := s.client.PosixRename(tmp, dest); err != nil {
+		s.client.Remove(dest)
+		return s.client.Rename(tmp, dest)
+	}
+	return nil
+}
+
+func (s *sftpStorage) Close() error {
+	s.client.Close()
+	return s.conn.Close()
+}
+
+func (s *sftpStorage) String() string {
+	return s.url
+}
@@ -0,0 +1,355 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// exerciseStorage uploads dumps to store, lists, reads and downloads them back
+func exerciseStorage(t *testing.T, store storage) {
+	monday, tuesday := sampleCustomArchive(14), sampleCustomArchive(15)
+	for key, content := range map[string][]byte{"db/monday.dump": monday, "db/tuesday.dump": tuesday, "notes.txt": []byte("not a dump")} {
+		if err := store.Put(key, bytes.NewReader(content)); err != nil {
+			t.Fatalf("put %s : %s", key, err)
+		}
+	}
+
+	objects, err := store.List("db/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, obj := range objects {
+		keys = append(keys, obj.key)
+	}
+	sort.Strings(keys)
+	if strings.Join(keys, ",") != "db/monday.dump,db/tuesday.dump" {
+		t.Fatalf("unexpected keys %v", keys)
+	}
+
+	obj, err := store.Stat(remoteObject{key: "db/tuesday.dump"})
+	if err != nil || obj.size != int64(len(tuesday)) || obj.etag == "" {
+		t.Fatalf("unexpected stat %+v (%v)", obj, err)
+	}
+	body, err := store.Open(obj, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := ioutil.ReadAll(body)
+	body.Close()
+	if !bytes.Equal(got, tuesday[100:]) {
+		t.Error("content read from offset differs")
+	}
+	if _, err := store.Stat(remoteObject{key: "db/missing.dump"}); err != errObjectNotFound {
+		t.Errorf("expected not found error, got %v", err)
+	}
+
+	dir, err := ioutil.TempDir("", "cappa-storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := Download(store, obj, "tuesday.dump", dir, ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+	downloaded, _ := ioutil.ReadFile(filepath.Join(dir, "tuesday.dump"))
+	if !bytes.Equal(downloaded, tuesday) {
+		t.Error("downloaded file differs")
+	}
+
+	// Replaced by a dump of another size, resuming must not mix both
+	if err := store.Put("db/tuesday.dump", bytes.NewReader(monday[:len(monday)-10])); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Open(obj, 100); err != errObjectChanged {
+		t.Errorf("expected changed object error, got %v", err)
+	}
+}
+
+func tempDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "cappa-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+func Test_LocalStorage(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	exerciseStorage(t, &localStorage{root: dir})
+}
+
+func Test_LocalStorageListsOnlyDumps(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	for _, name := range []string{"prod.dump", ".manifest", ".pull.json", "cappa-prod.dump.part", "cappa-prod.dump.part.etag", "execute.sql", ".cappa/old.dump", "db/hourly.dump"} {
+		os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755)
+		writeDumpFixture(t, dir, name, []byte("dump"))
+	}
+	objects, err := (&localStorage{root: dir}).List("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, obj := range objects {
+		keys = append(keys, obj.key)
+	}
+	sort.Strings(keys)
+	if strings.Join(keys, ",") != "db/hourly.dump,prod.dump" {
+		t.Errorf("unexpected keys %v", keys)
+	}
+}
+
+// httpIndexStandIn serves dir with directory indexes and ETags like nginx, PUT uploads like WebDAV
+func httpIndexStandIn(dir string) *httptest.Server {
+	files := http.FileServer(http.Dir(dir))
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, password, _ := r.BasicAuth(); user != "backups" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		p := filepath.Join(dir, filepath.FromSlash(path.Clean(r.URL.Path)))
+		if r.Method == http.MethodPut {
+			os.MkdirAll(filepath.Dir(p), 0755)
+			content, _ := ioutil.ReadAll(r.Body)
+			ioutil.WriteFile(p, content, 0644)
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		if info, err := os.Stat(p); err == nil && !info.IsDir() {
+			w.Header().Set("ETag", `"`+fileEtag(info.Size(), info.ModTime())+`"`)
+		}
+		files.ServeHTTP(w, r)
+	}))
+}
+
+func Test_HttpIndexStorage(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	server := httpIndexStandIn(dir)
+	defer server.Close()
+
+	u := strings.Replace(server.URL, "http://", "http://backups:secret@", 1) + "/"
+	store, prefix, err := openStorage(sourceConfig{Url: u})
+	if err != nil || prefix != "" {
+		t.Fatal(err)
+	}
+	if strings.Contains(store.String(), "secret") {
+		t.Errorf("password shown in %s", store)
+	}
+	exerciseStorage(t, store)
+}
+
+// fakeGcs stores objects of a bucket named backups and answers the JSON api
+type fakeGcs struct {
+	sync.Mutex
+	objects     map[string][]byte
+	generations map[string]int
+	generation  int
+}
+
+func (f *fakeGcs) item(name string) gcsObject {
+	sum := md5.Sum(f.objects[name])
+	return gcsObject{Name: name, Size: strconv.Itoa(len(f.objects[name])), Updated: time.Now(), Md5Hash: base64.StdEncoding.EncodeToString(sum[:]), Generation: strconv.Itoa(f.generations[name])}
+}
+
+func (f *fakeGcs) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/upload/storage/v1/b/backups/o":
+		name := r.URL.Query().Get("name")
+		f.objects[name], _ = ioutil.ReadAll(r.Body)
+		f.generation++
+		f.generations[name] = f.generation
+		json.NewEncoder(w).Encode(f.item(name))
+	case r.URL.Path == "/storage/v1/b/backups/o":
+		var page struct {
+			Items []gcsObject `json:"items"`
+		}
+		for name := range f.objects {
+			if strings.HasPrefix(name, r.URL.Query().Get("prefix")) {
+				page.Items = append(page.Items, f.item(name))
+			}
+		}
+		json.NewEncoder(w).Encode(page)
+	case strings.HasPrefix(r.URL.Path, "/storage/v1/b/backups/o/"):
+		name := strings.TrimPrefix(r.URL.Path, "/storage/v1/b/backups/o/")
+		content, ok := f.objects[name]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.URL.Query().Get("alt") != "media" {
+			json.NewEncoder(w).Encode(f.item(name))
+			return
+		}
+		if g := r.URL.Query().Get("ifGenerationMatch"); g != "" && g != strconv.Itoa(f.generations[name]) {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		http.ServeContent(w, r, name, time.Now(), bytes.NewReader(content))
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func Test_GcsStorage(t *testing.T) {
+	server := httptest.NewServer(&fakeGcs{objects: map[string][]byte{}, generations: map[string]int{}})
+	defer server.Close()
+
+	store, prefix, err := openStorage(sourceConfig{Url: "gs://backups/db/", Endpoint: server.URL})
+	if err != nil || prefix != "db/" {
+		t.Fatalf("unexpected prefix %q (%v)", prefix, err)
+	}
+	exerciseStorage(t, store)
+}
+
+// sftpStandIn runs a ssh server with the sftp subsystem, accepting backups:secret
+func sftpStandIn(t *testing.T, dir string) (addr string, knownHostsFile string, stop func()) {
+	_, hostKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(hostKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{PasswordCallback: func(c ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+		if c.User() == "backups" && string(password) == "secret" {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("access denied")
+	}}
+	config.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveSftp(conn, config)
+		}
+	}()
+
+	addr = listener.Addr().String()
+	knownHostsFile = filepath.Join(dir, "known_hosts")
+	ioutil.WriteFile(knownHostsFile, []byte(knownhosts.Line([]string{knownhosts.Normalize(addr)}, signer.PublicKey())+"\n"), 0600)
+	return addr, knownHostsFile, func() { listener.Close() }
+}
+
+func serveSftp(conn net.Conn, config *ssh.ServerConfig) {
+	_, channels, requests, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(requests)
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "only sessions")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+		go func(in <-chan *ssh.Request) {
+			for req := range in {
+				req.Reply(req.Type == "subsystem" && string(req.Payload[4:]) == "sftp", nil)
+			}
+		}(requests)
+		server, err := sftp.NewServer(channel)
+		if err != nil {
+			return
+		}
+		go func() {
+			server.Serve()
+			server.Close()
+		}()
+	}
+}
+
+func Test_SftpStorage(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	addr, knownHostsFile, stop := sftpStandIn(t, dir)
+	defer stop()
+	root := filepath.ToSlash(filepath.Join(dir, "backups"))
+
+	source := sourceConfig{Url: "sftp://backups@" + addr + root, Password: "wrong", KnownHosts: knownHostsFile}
+	if _, _, err := openStorage(source); err == nil {
+		t.Fatal("wrong password should be rejected")
+	}
+	source.Password = "secret"
+	store, _, err := openStorage(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	exerciseStorage(t, store)
+
+	// Unknown host keys are refused
+	ioutil.WriteFile(knownHostsFile, nil, 0600)
+	if _, _, err := openStorage(source); err == nil {
+		t.Error("unknown host key should be refused")
+	}
+}
+
+func Test_NamedSources(t *testing.T) {
+	viper.Set("sources.prod-hourly.url", "s3://backups/prod/")
+	viper.Set("sources.prod-hourly.prefix", "hourly/")
+	viper.Set("sources.prod-hourly.aws_profile", "backups")
+	viper.Set("sources.prod-hourly.region", "eu-west-3")
+	viper.Set("sources.prod-hourly.match", "*.dump")
+	viper.Set("sources.nas.url", "/mnt/backups")
+	defer viper.Set("sources", nil)
+
+	source, err := loadSource("prod-hourly")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if source.Match != "*.dump" || source.Aws.Profile != "backups" || source.Aws.Region != "eu-west-3" {
+		t.Errorf("source settings not read : %+v", source)
+	}
+	u, _ := url.Parse(source.Url)
+	if prefix := bucketPrefix(u, source); prefix != "prod/hourly/" {
+		t.Errorf("unexpected prefix %q", prefix)
+	}
+
+	if _, err := loadSource("staging"); err == nil || !strings.Contains(err.Error(), "nas, prod-hourly") {
+		t.Errorf("unknown source should list configured ones, got %v", err)
+	}
+	if source, err := loadSource("sftp://nas.local/backups"); err != nil || source.Url != "sftp://nas.local/backups" {
+		t.Errorf("urls are sources too (%v)", err)
+	}
+}
@@ -0,0 +1,148 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+)
+
+// Object types holding data, everything else is schema (DDL)
+var dataDescs = map[string]bool{
+	"TABLE DATA":             true,
+	"SEQUENCE SET":           true,
+	"BLOBS":                  true,
+	"BLOB DATA":              true,
+	"MATERIALIZED VIEW DATA": true,
+}
+
+// tocFilter selects which items of an archive are restored.
+// Table filters only apply to data so excluded tables are still created, empty.
+type tocFilter struct {
+	Tables        []string
+	ExcludeTables []string
+	Schemas       []string
+	SchemaOnly    bool
+	DataOnly      bool
+	// Extensions installed before restoring, pg_restore --clean would drop them then fail to create them again
+	InstalledExtensions []string
+}
+
+// empty tells if the user selected nothing, installed extensions are always skipped
+func (f tocFilter) empty() bool {
+	return len(f.Tables) == 0 && len(f.ExcludeTables) == 0 && len(f.Schemas) == 0 && !f.SchemaOnly && !f.DataOnly
+}
+
+func (f tocFilter) validate() error {
+	if f.SchemaOnly && f.DataOnly {
+		return fmt.Errorf("--schema-only and --data-only cannot be used together")
+	}
+	return nil
+}
+
+// keep tells if item must be restored
+func (f tocFilter) keep(item tocItem) bool {
+	for _, extension := range f.InstalledExtensions {
+		if (item.Desc == "EXTENSION" && item.Name == extension) || (item.Desc == "COMMENT" && item.Name == "EXTENSION "+extension) {
+			return false
+		}
+	}
+
+	isData := dataDescs[item.Desc]
+	if f.SchemaOnly && isData {
+		return false
+	}
+	if f.DataOnly && !isData {
+		return false
+	}
+
+	if len(f.Schemas) > 0 {
+		if item.Desc == "SCHEMA" {
+			if !matchAny(f.Schemas, item.Name) {
+				return false
+			}
+		} else if item.Schema != "-" && !matchAny(f.Schemas, item.Schema) {
+			return false
+		}
+	}
+
+	if item.Desc == "TABLE DATA" {
+		qualified := fmt.Sprintf("%s.%s", item.Schema, item.Name)
+		if len(f.Tables) > 0 && !matchAny(f.Tables, item.Name) && !matchAny(f.Tables, qualified) {
+			return false
+		}
+		if matchAny(f.ExcludeTables, item.Name) || matchAny(f.ExcludeTables, qualified) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchAny tells if name matches one of glob patterns (e.g. audit_*, public.events)
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// filterToc returns items kept by filter
+func filterToc(items []tocItem, filter tocFilter) []tocItem {
+	var kept []tocItem
+	for _, item := range items {
+		if filter.keep(item) {
+			kept = append(kept, item)
+		}
+	}
+	return kept
+}
+
+// writeTocList writes a list for `pg_restore --use-list`, excluded items are commented out
+func writeTocList(w io.Writer, items []tocItem, filter tocFilter) error {
+	for _, item := range items {
+		line := item.Line
+		if !filter.keep(item) {
+			line = ";" + line
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tocListFile writes the filtered list to a temporary file, caller must remove it
+func tocListFile(items []tocItem, filter tocFilter) (string, error) {
+	f, err := ioutil.TempFile("", "cappa-list-")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if err := writeTocList(f, items, filter); err != nil {
+		return f.Name(), err
+	}
+	return f.Name(), nil
+}
+
+func (f tocFilter) String() string {
+	var parts []string
+	if len(f.Schemas) > 0 {
+		parts = append(parts, "schemas "+strings.Join(f.Schemas, ", "))
+	}
+	if len(f.Tables) > 0 {
+		parts = append(parts, "data of "+strings.Join(f.Tables, ", "))
+	}
+	if len(f.ExcludeTables) > 0 {
+		parts = append(parts, "no data for "+strings.Join(f.ExcludeTables, ", "))
+	}
+	if f.SchemaOnly {
+		parts = append(parts, "schema only")
+	}
+	if f.DataOnly {
+		parts = append(parts, "data only")
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func Test_TocFilterExcludedTablesKeepTheirDdl(t *testing.T) {
+	items, err := parseTocList(strings.NewReader(sampleTocList))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var list bytes.Buffer
+	if err := writeTocList(&list, items, tocFilter{ExcludeTables: []string{"events"}}); err != nil {
+		t.Fatal(err)
+	}
+	out := list.String()
+	if !strings.Contains(out, "\n216; 1259 16392 TABLE public events postgres\n") {
+		t.Fatalf("events table must still be created :\n%s", out)
+	}
+	if !strings.Contains(out, "\n;3013; 0 16392 TABLE DATA public events postgres\n") {
+		t.Fatalf("events data must be commented out :\n%s", out)
+	}
+	if !strings.Contains(out, "\n3012; 0 16386 TABLE DATA public users postgres\n") {
+		t.Fatalf("users data must be restored :\n%s", out)
+	}
+}
+
+func Test_TocFilterSchemaAndDataOnly(t *testing.T) {
+	items, err := parseTocList(strings.NewReader(sampleTocList))
+	if err != nil {
+		t.Fatal(err)
+	}
+	schemaOnly := filterToc(items, tocFilter{SchemaOnly: true})
+	for _, item := range schemaOnly {
+		if item.Desc == "TABLE DATA" {
+			t.Fatalf("schema only restore must not contain data, got %+v", item)
+		}
+	}
+	dataOnly := filterToc(items, tocFilter{DataOnly: true, Tables: []string{"public.u*"}})
+	if len(dataOnly) != 1 || dataOnly[0].Name != "users" {
+		t.Fatalf("expected only users data, got %+v", dataOnly)
+	}
+	if err := (tocFilter{SchemaOnly: true, DataOnly: true}).validate(); err == nil {
+		t.Fatalf("schema only and data only are exclusive")
+	}
+}
+
+func Test_TocFilterSkipsInstalledExtensions(t *testing.T) {
+	items, err := parseTocList(strings.NewReader(sampleTocList))
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter := tocFilter{InstalledExtensions: []string{"pg_trgm"}}
+	if !filter.empty() {
+		t.Error("installed extensions are not a selection of the user")
+	}
+	var list bytes.Buffer
+	if err := writeTocList(&list, items, filter); err != nil {
+		t.Fatal(err)
+	}
+	out := list.String()
+	if !strings.Contains(out, ";2; 3079 16385 EXTENSION - pg_trgm") || !strings.Contains(out, ";3615; 0 0 COMMENT - EXTENSION pg_trgm") {
+		t.Fatalf("installed extension and its comment must be commented out :\n%s", out)
+	}
+	if !strings.Contains(out, "\n215; 1259 16386 TABLE public users postgres\n") {
+		t.Fatalf("tables must be restored :\n%s", out)
+	}
+}
@@ -0,0 +1,185 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// tocItem is one entry of the table of contents printed by `pg_restore -l`
+type tocItem struct {
+	DumpId int
+	Desc   string
+	Schema string
+	Name   string
+	Owner  string
+	Line   string
+}
+
+// multiWordDescs are object types made of several words, a type comes before any shorter type it starts with
+var multiWordDescs = []string{
+	"PUBLICATION TABLES IN SCHEMA",
+	"MATERIALIZED VIEW DATA",
+	"TEXT SEARCH CONFIGURATION",
+	"TEXT SEARCH DICTIONARY",
+	"TEXT SEARCH TEMPLATE",
+	"FOREIGN DATA WRAPPER",
+	"TEXT SEARCH PARSER",
+	"SUBSCRIPTION TABLE",
+	"DATABASE PROPERTIES",
+	"SEQUENCE OWNED BY",
+	"MATERIALIZED VIEW",
+	"PUBLICATION TABLE",
+	"PROCEDURAL LANGUAGE",
+	"OPERATOR FAMILY",
+	"CHECK CONSTRAINT",
+	"OPERATOR CLASS",
+	"FOREIGN SERVER",
+	"SECURITY LABEL",
+	"BLOB METADATA",
+	"EVENT TRIGGER",
+	"FOREIGN TABLE",
+	"ACCESS METHOD",
+	"FK CONSTRAINT",
+	"SEQUENCE SET",
+	"TABLE ATTACH",
+	"INDEX ATTACH",
+	"ROW SECURITY",
+	"USER MAPPING",
+	"LARGE OBJECT",
+	"DEFAULT ACL",
+	"SHELL TYPE",
+	"TABLE DATA",
+	"BLOB DATA",
+}
+
+// Entries which are never printed as restored items with --no-acl and without --create
+var silentDescs = map[string]bool{
+	"ENCODING":            true,
+	"STDSTRINGS":          true,
+	"SEARCHPATH":          true,
+	"DATABASE":            true,
+	"DATABASE PROPERTIES": true,
+	"ACL":                 true,
+	"DEFAULT ACL":         true,
+}
+
+// parseTocLine parses a line like `3012; 0 16390 TABLE DATA public users postgres`.
+// ok is false for comments and blank lines.
+func parseTocLine(line string) (item tocItem, ok bool, err error) {
+	trimmed := strings.TrimRight(line, "\r\n")
+	if strings.TrimSpace(trimmed) == "" || strings.HasPrefix(trimmed, ";") {
+		return item, false, nil
+	}
+	item.Line = trimmed
+
+	semicolon := strings.Index(trimmed, ";")
+	if semicolon < 0 {
+		return item, false, fmt.Errorf("invalid toc line %q", trimmed)
+	}
+	item.DumpId, err = strconv.Atoi(strings.TrimSpace(trimmed[:semicolon]))
+	if err != nil {
+		return item, false, fmt.Errorf("invalid toc line %q", trimmed)
+	}
+
+	// Skip catalog oid and object oid
+	rest := strings.TrimLeft(trimmed[semicolon+1:], " ")
+	for i := 0; i < 2; i++ {
+		space := strings.Index(rest, " ")
+		if space < 0 {
+			return item, false, fmt.Errorf("invalid toc line %q", trimmed)
+		}
+		rest = rest[space+1:]
+	}
+
+	for _, desc := range multiWordDescs {
+		if strings.HasPrefix(rest, desc+" ") {
+			item.Desc = desc
+			break
+		}
+	}
+	if item.Desc == "" {
+		space := strings.Index(rest, " ")
+		if space < 0 {
+			return item, false, fmt.Errorf("invalid toc line %q", trimmed)
+		}
+		item.Desc = rest[:space]
+	}
+	rest = strings.TrimPrefix(rest, item.Desc+" ")
+
+	space := strings.Index(rest, " ")
+	if space < 0 {
+		return item, false, fmt.Errorf("invalid toc line %q", trimmed)
+	}
+	item.Schema = rest[:space]
+	rest = rest[space+1:]
+
+	// Owner is the last word and may be empty, name may contain spaces
+	last := strings.LastIndex(rest, " ")
+	if last < 0 {
+		item.Name = rest
+	} else {
+		item.Name = rest[:last]
+		item.Owner = rest[last+1:]
+	}
+	return item, true, nil
+}
+
+// parseTocList reads the output of `pg_restore -l`
+func parseTocList(r io.Reader) ([]tocItem, error) {
+	var items []tocItem
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		item, ok, err := parseTocLine(scanner.Text())
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			items = append(items, item)
+		}
+	}
+	return items, scanner.Err()
+}
+
+// listArchive returns the table of contents of an archive using `pg_restore -l`.
+// Compressed or encrypted archives and streams are piped, pg_restore stops reading once the table of contents is printed.
+func listArchive(tool pgTool, input *dumpInput) ([]tocItem, error) {
+	if input.local() && input.Kind.plainFile() {
+		return runTocList(exec.Command(tool.Path, "-l", input.Path))
+	}
+	var items []tocItem
+	err := input.inspect(func(r io.Reader) error {
+		cmd := exec.Command(tool.Path, "-l")
+		cmd.Stdin = r
+		var err error
+		items, err = runTocList(cmd)
+		return err
+	})
+	return items, err
+}
+
+func runTocList(cmd *exec.Cmd) ([]tocItem, error) {
+	out, err := cmd.Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return nil, fmt.Errorf("%s : %s", err, strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return nil, err
+	}
+	return parseTocList(strings.NewReader(string(out)))
+}
+
+// restorableCount counts items pg_restore will report as restored
+func restorableCount(items []tocItem) int {
+	count := 0
+	for _, item := range items {
+		if !silentDescs[item.Desc] {
+			count++
+		}
+	}
+	return count
+}
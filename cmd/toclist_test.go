@@ -0,0 +1,46 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+)
+
+const sampleTocList = `;
+; Archive created at 2026-10-01 03:00:12 UTC
+;     dbname: production
+;     TOC Entries: 7
+;
+2; 3079 16385 EXTENSION - pg_trgm 
+3615; 0 0 COMMENT - EXTENSION pg_trgm 
+215; 1259 16386 TABLE public users postgres
+216; 1259 16392 TABLE public events postgres
+3012; 0 16386 TABLE DATA public users postgres
+3013; 0 16392 TABLE DATA public events postgres
+3401; 0 0 ACL - SCHEMA public postgres
+`
+
+func Test_ParseTocList(t *testing.T) {
+	items, err := parseTocList(strings.NewReader(sampleTocList))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 7 {
+		t.Fatalf("expected 7 items, got %d", len(items))
+	}
+
+	extension := items[0]
+	if extension.Desc != "EXTENSION" || extension.Schema != "-" || extension.Name != "pg_trgm" || extension.Owner != "" {
+		t.Fatalf("unexpected extension item %+v", extension)
+	}
+	comment := items[1]
+	if comment.Desc != "COMMENT" || comment.Name != "EXTENSION pg_trgm" {
+		t.Fatalf("unexpected comment item %+v", comment)
+	}
+	data := items[4]
+	if data.DumpId != 3012 || data.Desc != "TABLE DATA" || data.Schema != "public" || data.Name != "users" || data.Owner != "postgres" {
+		t.Fatalf("unexpected table data item %+v", data)
+	}
+	if restorableCount(items) != 6 {
+		t.Fatalf("ACL must not be counted as restored with --no-acl")
+	}
+}
@@ -5,6 +5,7 @@ go 1.13
 replace golang.org/x/sys => golang.org/x/sys v0.0.0-20200826173525-f9321e4c35a6
 
 require (
+	filippo.io/age v1.0.0
 	github.com/AlecAivazis/survey/v2 v2.1.1
 	github.com/BurntSushi/toml v0.3.1
 	github.com/aws/aws-sdk-go v1.33.10
@@ -14,6 +15,7 @@ require (
 	github.com/fsnotify/fsnotify v1.4.9 // indirect
 	github.com/go-playground/validator/v10 v10.3.0
 	github.com/jackc/pgx/v4 v4.8.1
+	github.com/klauspost/compress v1.11.13
 	github.com/konsorten/go-windows-terminal-sequences v1.0.3 // indirect
 	github.com/lib/pq v1.3.0
 	github.com/lithammer/shortuuid/v3 v3.0.4
@@ -27,6 +29,8 @@ require (
 	github.com/ory/dockertest v3.3.5+incompatible // indirect
 	github.com/ory/dockertest/v3 v3.6.0
 	github.com/pelletier/go-toml v1.8.1 // indirect
+	github.com/pierrec/lz4/v4 v4.1.3
+	github.com/pkg/sftp v1.13.4
 	github.com/rs/zerolog v1.19.0
 	github.com/satori/go.uuid v1.2.0
 	github.com/sirupsen/logrus v1.7.0 // indirect
@@ -34,13 +38,14 @@ require (
 	github.com/spf13/cast v1.3.1 // indirect
 	github.com/spf13/cobra v1.0.0
 	github.com/spf13/jwalterweatherman v1.1.0 // indirect
-	github.com/spf13/pflag v1.0.5 // indirect
+	github.com/spf13/pflag v1.0.5
 	github.com/spf13/viper v1.7.1
 	github.com/ttacon/chalk v0.0.0-20160626202418-22c06c80ed31
+	github.com/ulikunitz/xz v0.5.10
 	github.com/xeonx/timeago v1.0.0-rc4
 	github.com/xo/dburl v0.0.0-20200910011426-652e0d5720a3
-	golang.org/x/net v0.0.0-20200927032502-5d4f70055728 // indirect
-	golang.org/x/sys v0.0.0-20200926100807-9d91bd62050c // indirect
+	golang.org/x/crypto v0.0.0-20210817164053-32db794688a5
+	golang.org/x/oauth2 v0.0.0-20210323180902-22b0adad7558
 	gopkg.in/check.v1 v1.0.0-20190902080502-41f04d3bba15 // indirect
 	gopkg.in/cheggaaa/pb.v1 v1.0.28
 	gopkg.in/ini.v1 v1.61.0 // indirect